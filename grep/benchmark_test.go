@@ -0,0 +1,45 @@
+package grep
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func benchmarkData() []byte {
+	line := "INFO alpha beta gamma delta ERROR target_token omega sigma tau\n"
+	return bytes.Repeat([]byte(line), 4096)
+}
+
+func BenchmarkDoGrepFixedUTF8(b *testing.B) {
+	data := benchmarkData()
+	s, err := New(&Options{Pattern: "target_token", Fixed: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	arg := &grepArg{ctx: context.Background()}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		arg.matches = arg.matches[:0]
+		s.doGrepFixedUTF8("bench.txt", data, arg, s.matcher)
+	}
+}
+
+func BenchmarkDoGrepFixedUTF8IgnoreCaseASCII(b *testing.B) {
+	data := []byte(strings.ReplaceAll(string(benchmarkData()), "target_token", "TaRgEt_ToKeN"))
+	s, err := New(&Options{Pattern: "target_token", Fixed: true, IgnoreCase: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	arg := &grepArg{ctx: context.Background()}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		arg.matches = arg.matches[:0]
+		s.doGrepFixedUTF8("bench.txt", data, arg, s.matcher)
+	}
+}
@@ -0,0 +1,775 @@
+package grep
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/transform"
+)
+
+// detectSampleSize is the number of bytes inspected by Detect.
+const detectSampleSize = 1 << 20
+
+// Detection is the result of guessing the encoding of a text.
+type Detection struct {
+	Encoding   string      // most likely encoding, or "" if none is valid
+	Confidence float64     // confidence in Encoding, from 0 to 1
+	Candidates []Candidate // encodings tried, the most likely first
+}
+
+// Candidate holds the evidence found for one encoding.
+type Candidate struct {
+	Encoding string  // name of the encoding
+	Valid    bool    // the text is well-formed in the encoding
+	Score    float64 // weight of the evidence, higher is more likely
+	Reason   string  // summary of the evidence
+}
+
+// String returns a summary of d for verbose messages.
+func (d *Detection) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (confidence %.2f)", d.Encoding, d.Confidence)
+	for _, c := range d.Candidates {
+		if c.Valid {
+			fmt.Fprintf(&sb, "; %s=%.1f: %s", c.Encoding, c.Score, c.Reason)
+		} else {
+			fmt.Fprintf(&sb, "; %s rejected: %s", c.Encoding, c.Reason)
+		}
+	}
+	return sb.String()
+}
+
+// Detect guesses which of encodings the text b is written in. Each encoding
+// is scored by the characters b decodes to in it: kana, hangul and common
+// kanji are strong evidence, while undefined or user-defined characters, or
+// kana in Chinese and Korean encodings, are weak or count against it.
+// Encodings that b is not well-formed in are rejected. Only the first 1MB of
+// b is inspected.
+func Detect(b []byte, encodings []string) *Detection {
+	if len(b) > detectSampleSize {
+		b = b[:detectSampleSize]
+	}
+	d := &Detection{}
+	if isPlainASCII(b) {
+		for _, enc := range encodings {
+			c := Candidate{Encoding: enc, Reason: "ascii only"}
+			switch name := canonicalEncoding(enc); {
+			case name == "utf-8":
+				// the text is UTF-8 as well, so prefer it
+				c.Valid = true
+				c.Score = 1
+			case name != "iso-2022-jp" && asciiCompatible(name):
+				c.Valid = true
+			}
+			d.Candidates = append(d.Candidates, c)
+		}
+		sortCandidates(d)
+		if len(d.Candidates) > 0 && d.Candidates[0].Valid {
+			d.Encoding = d.Candidates[0].Encoding
+			d.Confidence = 1
+		}
+		return d
+	}
+
+	total := 0.0
+	for _, enc := range encodings {
+		c := Candidate{Encoding: enc}
+		var cs charStats
+		var err error
+		switch canonicalEncoding(enc) {
+		case "utf-8":
+			cs, err = scanUTF8(b)
+		case "shift_jis":
+			cs, err = scanShiftJIS(b)
+		case "euc-jp":
+			cs, err = scanEUCJP(b)
+		case "iso-2022-jp":
+			cs, err = scanISO2022JP(b)
+		case "utf-16le":
+			cs, err = scanUTF16(b, false)
+		case "utf-16be":
+			cs, err = scanUTF16(b, true)
+		case "utf-32le":
+			cs, err = scanUTF32(b, false)
+		case "utf-32be":
+			cs, err = scanUTF32(b, true)
+		case "gb18030", "gbk":
+			cs, err = scanGB(b, canonicalEncoding(enc) == "gb18030")
+		case "big5":
+			cs, err = scanBig5(b)
+		case "euc-kr":
+			cs, err = scanEUCKR(b)
+		case "windows-1252":
+			cs, err = scanWindows1252(b)
+		default:
+			cs, err = scanGeneric(b, enc)
+		}
+		if err != nil {
+			c.Reason = err.Error()
+		} else {
+			c.Valid = true
+			c.Score = cs.score()
+			c.Reason = cs.String()
+			if c.Score > 0 {
+				total += c.Score
+			}
+		}
+		d.Candidates = append(d.Candidates, c)
+	}
+	sortCandidates(d)
+	if len(d.Candidates) > 0 && d.Candidates[0].Valid {
+		best := d.Candidates[0]
+		d.Encoding = best.Encoding
+		if best.Score > 0 {
+			d.Confidence = best.Score / total
+		}
+	}
+	return d
+}
+
+// Encodings returns the valid encodings of d, the most likely first.
+func (d *Detection) Encodings() []string {
+	var encs []string
+	for _, c := range d.Candidates {
+		if c.Valid {
+			encs = append(encs, c.Encoding)
+		}
+	}
+	return encs
+}
+
+// sortCandidates puts the valid candidates of d first, in order of score.
+// Candidates with the same score keep the order of the encodings list.
+func sortCandidates(d *Detection) {
+	sort.SliceStable(d.Candidates, func(i, j int) bool {
+		ci, cj := d.Candidates[i], d.Candidates[j]
+		if ci.Valid != cj.Valid {
+			return ci.Valid
+		}
+		return ci.Score > cj.Score
+	})
+}
+
+func isPlainASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 || c == 0x1b {
+			return false
+		}
+	}
+	return true
+}
+
+// charClass is a class of characters weighted by how common it is in text.
+type charClass int
+
+const (
+	clsHiragana charClass = iota
+	clsKatakana
+	clsKanji     // kanji of JIS level 1, or any kanji in Unicode
+	clsKanji2    // kanji of JIS level 2 and vendor extensions
+	clsSymbol    // punctuation and symbols
+	clsAlnum     // full-width letters and digits
+	clsOther     // greek, cyrillic, box drawing and such
+	clsHalfKana  // half-width katakana
+	clsLatin     // latin letters with diacritics
+	clsGeneric   // characters of an encoding without a dedicated scanner
+	clsRare      // undefined, user-defined and supplementary characters
+	clsHangul    // hangul syllables
+	clsCommon    // most frequent characters of Chinese and Korean
+	numCharClass // number of classes
+)
+
+var charClassNames = [numCharClass]string{
+	"hiragana", "katakana", "kanji", "kanji2", "symbol", "alnum",
+	"other", "halfkana", "latin", "generic", "rare", "hangul", "common",
+}
+
+var charClassWeights = [numCharClass]float64{
+	3, 2, 2, 0.5, 1.5, 1,
+	0.5, 0.3, 1, 0.5, -2, 2, 3,
+}
+
+// charStats counts the non-ASCII characters of a text by class.
+type charStats struct {
+	counts [numCharClass]int
+	bonus  float64
+}
+
+func (cs *charStats) add(c charClass) {
+	cs.counts[c]++
+}
+
+func (cs *charStats) score() float64 {
+	score := cs.bonus
+	for c, n := range cs.counts {
+		score += float64(n) * charClassWeights[c]
+	}
+	return score
+}
+
+func (cs *charStats) String() string {
+	var parts []string
+	for c, n := range cs.counts {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", charClassNames[c], n))
+		}
+	}
+	if len(parts) == 0 {
+		return "no characters"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// jisClass returns the class of the JIS X 0208 character at row and cell.
+func jisClass(row, cell int) charClass {
+	switch {
+	case row == 0x21:
+		return clsSymbol
+	case row == 0x22:
+		if cell <= 0x2e || (cell >= 0x3a && cell <= 0x41) || (cell >= 0x4a && cell <= 0x50) ||
+			(cell >= 0x5c && cell <= 0x6a) || (cell >= 0x72 && cell <= 0x79) || cell == 0x7e {
+			return clsSymbol
+		}
+	case row == 0x23:
+		if (cell >= 0x30 && cell <= 0x39) || (cell >= 0x41 && cell <= 0x5a) || (cell >= 0x61 && cell <= 0x7a) {
+			return clsAlnum
+		}
+	case row == 0x24:
+		if cell <= 0x73 {
+			return clsHiragana
+		}
+	case row == 0x25:
+		if cell <= 0x76 {
+			return clsKatakana
+		}
+	case row >= 0x26 && row <= 0x28, row == 0x2d:
+		return clsOther
+	case row >= 0x30 && row <= 0x4f:
+		if row < 0x4f || cell <= 0x53 {
+			return clsKanji
+		}
+	case row >= 0x50 && row <= 0x74:
+		if row < 0x74 || cell <= 0x26 {
+			return clsKanji2
+		}
+	}
+	return clsRare
+}
+
+// commonChars are the most frequent characters of the languages of the
+// Chinese and Korean encodings. Kana tell Japanese from them, but their
+// kanji and hangul fall on the same byte ranges.
+var commonChars = []struct {
+	enc   string
+	e     encoding.Encoding
+	chars string
+}{
+	{"gbk", simplifiedchinese.GBK, "的一是不了人我在有他这中大来上个国到说们为子和你地出道也时年得就那要下以生会自着去之过家学对可她里后小么心多天而能好都然没日于起还发成事只作当想看文无开手十用主行方又如前所本见经头面公同三已老从动两长"},
+	{"big5", traditionalchinese.Big5, "的一是不了人我在有他這中大來上個國到說們為子和你地出道也時年得就那要下以生會自著去之過家學對可她裡後小麼心多天而能好都然沒日於起還發成事只作當想看文無開手十用主行方又如前所本見經頭面公同三已老從動兩長"},
+	{"euc-kr", korean.EUCKR, "이다의는에을를하고가한로지서기사자리대것수들도인있시정어그으일나해보부아게우전제주장적화상라만요내였과성계생구면국"},
+}
+
+var (
+	commonOnce  sync.Once
+	commonCodes map[string]map[uint16]bool
+)
+
+// isCommon reports whether the double-byte character c1 c2 is one of the
+// commonChars of the encoding enc.
+func isCommon(enc string, c1, c2 byte) bool {
+	commonOnce.Do(func() {
+		commonCodes = map[string]map[uint16]bool{}
+		for _, c := range commonChars {
+			b, err := c.e.NewEncoder().Bytes([]byte(c.chars))
+			if err != nil {
+				panic(err)
+			}
+			codes := map[uint16]bool{}
+			for i := 0; i+1 < len(b); i += 2 {
+				codes[uint16(b[i])<<8|uint16(b[i+1])] = true
+			}
+			commonCodes[c.enc] = codes
+		}
+	})
+	return commonCodes[enc][uint16(c1)<<8|uint16(c2)]
+}
+
+// gbClass returns the class of the GB 2312 character in row.
+func gbClass(row byte) charClass {
+	switch {
+	case row <= 0xa2:
+		return clsSymbol
+	case row == 0xa3:
+		return clsAlnum
+	case row <= 0xa9:
+		// kana is rare in Chinese
+		return clsOther
+	case row >= 0xb0 && row <= 0xd7:
+		return clsKanji
+	case row >= 0xd8 && row <= 0xf7:
+		return clsKanji2
+	}
+	return clsRare
+}
+
+// ksClass returns the class of the KS X 1001 character in row.
+func ksClass(row byte) charClass {
+	switch {
+	case row <= 0xa2:
+		return clsSymbol
+	case row == 0xa3:
+		return clsAlnum
+	case row <= 0xac:
+		// jamo and kana are rare in Korean
+		return clsOther
+	case row >= 0xb0 && row <= 0xc8:
+		return clsHangul
+	case row >= 0xca && row <= 0xfd:
+		return clsKanji2
+	}
+	return clsRare
+}
+
+// runeClass returns the class of the Unicode character r.
+func runeClass(r rune) charClass {
+	switch {
+	case r >= 0x3041 && r <= 0x309f:
+		return clsHiragana
+	case r >= 0x30a0 && r <= 0x30ff:
+		return clsKatakana
+	case r >= 0x4e00 && r <= 0x9fff:
+		return clsKanji
+	case r >= 0x3400 && r <= 0x4dbf, r >= 0xf900 && r <= 0xfaff:
+		return clsKanji2
+	case r >= 0xac00 && r <= 0xd7a3:
+		return clsHangul
+	case r >= 0x3000 && r <= 0x303f, r >= 0xff01 && r <= 0xff0f, r >= 0x2010 && r <= 0x206f:
+		return clsSymbol
+	case r >= 0xff10 && r <= 0xff5e:
+		return clsAlnum
+	case r >= 0xff61 && r <= 0xff9f:
+		return clsHalfKana
+	case r >= 0x80 && r <= 0x24f:
+		return clsLatin
+	case r >= 0xe000 && r <= 0xf8ff, r > 0xffff:
+		return clsRare
+	}
+	return clsOther
+}
+
+// truncated reports whether the text ends inside the character at i because
+// of the sample size.
+func truncated(b []byte, i, n int) bool {
+	return len(b) == detectSampleSize && i+n > len(b)
+}
+
+func scanUTF8(b []byte) (charStats, error) {
+	var cs charStats
+	for i := 0; i < len(b); {
+		if b[i] < 0x80 {
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && n == 1 {
+			if truncated(b, i, utf8.UTFMax) {
+				break
+			}
+			return cs, fmt.Errorf("invalid byte 0x%02x at %d", b[i], i)
+		}
+		cs.add(runeClass(r))
+		// multibyte sequences are rarely well-formed by chance
+		cs.bonus++
+		i += n
+	}
+	return cs, nil
+}
+
+func scanShiftJIS(b []byte) (charStats, error) {
+	var cs charStats
+	for i := 0; i < len(b); i++ {
+		c1 := b[i]
+		switch {
+		case c1 < 0x80:
+		case c1 >= 0xa1 && c1 <= 0xdf:
+			cs.add(clsHalfKana)
+		case (c1 >= 0x81 && c1 <= 0x9f) || (c1 >= 0xe0 && c1 <= 0xfc):
+			if i+1 >= len(b) {
+				if truncated(b, i, 2) {
+					return cs, nil
+				}
+				return cs, fmt.Errorf("truncated character at %d", i)
+			}
+			c2 := b[i+1]
+			if c2 < 0x40 || c2 == 0x7f || c2 > 0xfc {
+				return cs, fmt.Errorf("invalid trail byte 0x%02x at %d", c2, i+1)
+			}
+			switch {
+			case c1 >= 0xf0 && c1 <= 0xf9:
+				cs.add(clsRare)
+			case c1 == 0xed || c1 == 0xee || c1 >= 0xfa:
+				cs.add(clsKanji2)
+			default:
+				if c1 >= 0xe0 {
+					c1 -= 0x40
+				}
+				row := int(c1-0x81)*2 + 0x21
+				cell := int(c2) - 0x1f
+				if c2 >= 0x9f {
+					row++
+					cell = int(c2) - 0x7e
+				} else if c2 >= 0x80 {
+					cell--
+				}
+				cs.add(jisClass(row, cell))
+			}
+			i++
+		default:
+			return cs, fmt.Errorf("invalid byte 0x%02x at %d", c1, i)
+		}
+	}
+	return cs, nil
+}
+
+func scanEUCJP(b []byte) (charStats, error) {
+	var cs charStats
+	for i := 0; i < len(b); i++ {
+		c1 := b[i]
+		n := 2
+		switch {
+		case c1 < 0x80:
+			continue
+		case c1 == 0x8e:
+		case c1 == 0x8f:
+			n = 3
+		case c1 >= 0xa1 && c1 <= 0xfe:
+		default:
+			return cs, fmt.Errorf("invalid byte 0x%02x at %d", c1, i)
+		}
+		if i+n > len(b) {
+			if truncated(b, i, n) {
+				return cs, nil
+			}
+			return cs, fmt.Errorf("truncated character at %d", i)
+		}
+		for j := 1; j < n; j++ {
+			if c := b[i+j]; c < 0xa1 || c > 0xfe || (c1 == 0x8e && c > 0xdf) {
+				return cs, fmt.Errorf("invalid trail byte 0x%02x at %d", c, i+j)
+			}
+		}
+		switch c1 {
+		case 0x8e:
+			cs.add(clsHalfKana)
+		case 0x8f:
+			cs.add(clsRare)
+		default:
+			cs.add(jisClass(int(c1-0x80), int(b[i+1]-0x80)))
+		}
+		i += n - 1
+	}
+	return cs, nil
+}
+
+func scanISO2022JP(b []byte) (charStats, error) {
+	var cs charStats
+	jis := false
+	escapes := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if c >= 0x80 {
+			return cs, fmt.Errorf("invalid byte 0x%02x at %d", c, i)
+		}
+		if c == 0x1b {
+			if i+2 >= len(b) {
+				if truncated(b, i, 3) {
+					break
+				}
+				return cs, fmt.Errorf("truncated escape sequence at %d", i)
+			}
+			switch string(b[i+1 : i+3]) {
+			case "(B", "(J":
+				jis = false
+			case "$@", "$B":
+				jis = true
+			case "(I":
+				jis = false
+				cs.add(clsHalfKana)
+			default:
+				return cs, fmt.Errorf("unknown escape sequence at %d", i)
+			}
+			escapes++
+			i += 2
+			continue
+		}
+		if !jis || c == '\n' || c == '\r' {
+			continue
+		}
+		if i+1 >= len(b) {
+			return cs, fmt.Errorf("truncated character at %d", i)
+		}
+		if c < 0x21 || c > 0x7e || b[i+1] < 0x21 || b[i+1] > 0x7e {
+			return cs, fmt.Errorf("invalid character at %d", i)
+		}
+		cs.add(jisClass(int(c), int(b[i+1])))
+		i++
+	}
+	if escapes == 0 {
+		return cs, fmt.Errorf("no escape sequence")
+	}
+	return cs, nil
+}
+
+func scanUTF16(b []byte, bigEndian bool) (charStats, error) {
+	var cs charStats
+	if len(b)%2 != 0 && !truncated(b, len(b)-1, 2) {
+		return cs, fmt.Errorf("odd length")
+	}
+	for i := 0; i+1 < len(b); i += 2 {
+		u := rune(b[i]) | rune(b[i+1])<<8
+		if bigEndian {
+			u = rune(b[i])<<8 | rune(b[i+1])
+		}
+		switch {
+		case u == '\t' || u == '\n' || u == '\r':
+		case u < 0x20:
+			return cs, fmt.Errorf("control character at %d", i)
+		case u < 0x80:
+		case u >= 0xd800 && u <= 0xdbff:
+			if i+3 >= len(b) {
+				break
+			}
+			l := rune(b[i+2]) | rune(b[i+3])<<8
+			if bigEndian {
+				l = rune(b[i+2])<<8 | rune(b[i+3])
+			}
+			if l < 0xdc00 || l > 0xdfff {
+				return cs, fmt.Errorf("unpaired surrogate at %d", i)
+			}
+			cs.add(clsRare)
+			i += 2
+		case u >= 0xdc00 && u <= 0xdfff:
+			return cs, fmt.Errorf("unpaired surrogate at %d", i)
+		default:
+			cs.add(runeClass(u))
+		}
+	}
+	// a text of ASCII bytes only is not UTF-16
+	if bytes.IndexByte(b, '\n') >= 0 && bytes.Index(b, utf16Newline(bigEndian)) < 0 {
+		return cs, fmt.Errorf("no newline in UTF-16")
+	}
+	return cs, nil
+}
+
+func scanUTF32(b []byte, bigEndian bool) (charStats, error) {
+	var cs charStats
+	if len(b)%4 != 0 && !truncated(b, len(b)-1, 4) {
+		return cs, fmt.Errorf("length is not a multiple of 4")
+	}
+	for i := 0; i+3 < len(b); i += 4 {
+		r := rune(b[i]) | rune(b[i+1])<<8 | rune(b[i+2])<<16 | rune(b[i+3])<<24
+		if bigEndian {
+			r = rune(b[i])<<24 | rune(b[i+1])<<16 | rune(b[i+2])<<8 | rune(b[i+3])
+		}
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+		case r < 0x20:
+			return cs, fmt.Errorf("control character at %d", i)
+		case r < 0x80:
+		case r > unicode.MaxRune || (r >= 0xd800 && r <= 0xdfff):
+			return cs, fmt.Errorf("invalid character at %d", i)
+		default:
+			cs.add(runeClass(r))
+			// code units are rarely well-formed by chance
+			cs.bonus++
+		}
+	}
+	return cs, nil
+}
+
+// scanGB scans GBK, or GB18030 if gb18030 is true.
+func scanGB(b []byte, gb18030 bool) (charStats, error) {
+	var cs charStats
+	for i := 0; i < len(b); i++ {
+		c1 := b[i]
+		if c1 < 0x80 {
+			continue
+		}
+		if c1 == 0x80 || c1 == 0xff {
+			return cs, fmt.Errorf("invalid byte 0x%02x at %d", c1, i)
+		}
+		if i+1 >= len(b) {
+			if truncated(b, i, 2) {
+				break
+			}
+			return cs, fmt.Errorf("truncated character at %d", i)
+		}
+		c2 := b[i+1]
+		switch {
+		case gb18030 && c2 >= 0x30 && c2 <= 0x39:
+			if i+3 >= len(b) {
+				if truncated(b, i, 4) {
+					return cs, nil
+				}
+				return cs, fmt.Errorf("truncated character at %d", i)
+			}
+			if b[i+2] < 0x81 || b[i+2] == 0xff || b[i+3] < 0x30 || b[i+3] > 0x39 {
+				return cs, fmt.Errorf("invalid character at %d", i)
+			}
+			cs.add(clsRare)
+			i += 3
+			continue
+		case c2 < 0x40 || c2 == 0x7f || c2 == 0xff:
+			return cs, fmt.Errorf("invalid trail byte 0x%02x at %d", c2, i+1)
+		case isCommon("gbk", c1, c2):
+			cs.add(clsCommon)
+		case c1 >= 0xa1 && c2 >= 0xa1:
+			cs.add(gbClass(c1))
+		default:
+			// the extension of GBK
+			cs.add(clsKanji2)
+		}
+		i++
+	}
+	return cs, nil
+}
+
+func scanBig5(b []byte) (charStats, error) {
+	var cs charStats
+	for i := 0; i < len(b); i++ {
+		c1 := b[i]
+		if c1 < 0x80 {
+			continue
+		}
+		if c1 == 0x80 || c1 == 0xff {
+			return cs, fmt.Errorf("invalid byte 0x%02x at %d", c1, i)
+		}
+		if i+1 >= len(b) {
+			if truncated(b, i, 2) {
+				break
+			}
+			return cs, fmt.Errorf("truncated character at %d", i)
+		}
+		c2 := b[i+1]
+		if c2 < 0x40 || (c2 > 0x7e && c2 < 0xa1) || c2 == 0xff {
+			return cs, fmt.Errorf("invalid trail byte 0x%02x at %d", c2, i+1)
+		}
+		switch code := int(c1)<<8 | int(c2); {
+		case isCommon("big5", c1, c2):
+			cs.add(clsCommon)
+		case c1 >= 0xa1 && c1 <= 0xa3:
+			cs.add(clsSymbol)
+		case code >= 0xa440 && code <= 0xc67e:
+			cs.add(clsKanji)
+		case c1 >= 0xc6 && c1 <= 0xc8:
+			// kana and such, rare in Chinese
+			cs.add(clsOther)
+		case code >= 0xc940 && code <= 0xf9d5:
+			cs.add(clsKanji2)
+		case c1 == 0xf9:
+			cs.add(clsOther)
+		default:
+			cs.add(clsRare)
+		}
+		i++
+	}
+	return cs, nil
+}
+
+// scanEUCKR scans EUC-KR with the extension of CP949.
+func scanEUCKR(b []byte) (charStats, error) {
+	var cs charStats
+	for i := 0; i < len(b); i++ {
+		c1 := b[i]
+		if c1 < 0x80 {
+			continue
+		}
+		if c1 == 0x80 || c1 == 0xff {
+			return cs, fmt.Errorf("invalid byte 0x%02x at %d", c1, i)
+		}
+		if i+1 >= len(b) {
+			if truncated(b, i, 2) {
+				break
+			}
+			return cs, fmt.Errorf("truncated character at %d", i)
+		}
+		c2 := b[i+1]
+		switch {
+		case isCommon("euc-kr", c1, c2):
+			cs.add(clsCommon)
+		case c1 >= 0xa1 && c2 >= 0xa1 && c2 <= 0xfe:
+			cs.add(ksClass(c1))
+		case c1 <= 0xc6 && ((c2 >= 0x41 && c2 <= 0x5a) || (c2 >= 0x61 && c2 <= 0x7a) || (c2 >= 0x81 && c2 <= 0xfe)):
+			// hangul of the extension of CP949
+			cs.add(clsOther)
+		default:
+			return cs, fmt.Errorf("invalid trail byte 0x%02x at %d", c2, i+1)
+		}
+		i++
+	}
+	return cs, nil
+}
+
+func scanWindows1252(b []byte) (charStats, error) {
+	var cs charStats
+	prev := byte(0)
+	for i, c := range b {
+		switch {
+		case c < 0x80:
+		case c == 0x81 || c == 0x8d || c == 0x8f || c == 0x90 || c == 0x9d:
+			return cs, fmt.Errorf("undefined byte 0x%02x at %d", c, i)
+		case prev >= 0x80:
+			// letters with diacritics seldom come in a row
+			cs.add(clsRare)
+		case c >= 0xc0 && c != 0xd7 && c != 0xf7:
+			cs.add(clsLatin)
+		default:
+			cs.add(clsOther)
+		}
+		prev = c
+	}
+	return cs, nil
+}
+
+func utf16Newline(bigEndian bool) []byte {
+	if bigEndian {
+		return []byte{0, '\n'}
+	}
+	return []byte{'\n', 0}
+}
+
+// scanGeneric decodes b with the encoding enc, which has no dedicated
+// scanner, and counts the characters it decodes to.
+func scanGeneric(b []byte, enc string) (charStats, error) {
+	var cs charStats
+	e, _ := lookupEncoding(enc)
+	if e == nil {
+		return cs, fmt.Errorf("unknown encoding")
+	}
+	t, _, err := transform.Bytes(e.NewDecoder(), b)
+	if err != nil {
+		return cs, err
+	}
+	for i := 0; i < len(t); {
+		r, n := utf8.DecodeRune(t[i:])
+		if r == utf8.RuneError {
+			if i+n == len(t) && truncated(b, len(b)-1, 2) {
+				break
+			}
+			return cs, fmt.Errorf("invalid character at %d", i)
+		}
+		if r >= 0x80 {
+			cs.add(clsGeneric)
+		}
+		i += n
+	}
+	return cs, nil
+}
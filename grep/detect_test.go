@@ -0,0 +1,115 @@
+package grep
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		text string
+		enc  encoding.Encoding
+		want string
+	}{
+		{"hello world\n", nil, "utf-8"},
+		{"日本語のテキスト\n", nil, "utf-8"},
+		{"日本語のテキスト\n", japanese.ShiftJIS, "sjis"},
+		{"日本語のテキスト\n", japanese.EUCJP, "euc-jp"},
+		{"日本語のテキスト\n", japanese.ISO2022JP, "iso-2022-jp"},
+		{"これは\n", japanese.ShiftJIS, "sjis"},
+		{"これは\n", japanese.EUCJP, "euc-jp"},
+		{"漢字\n", japanese.ShiftJIS, "sjis"},
+		{"漢字\n", japanese.EUCJP, "euc-jp"},
+		{"func main() {} // ｱｲｳ\n", japanese.ShiftJIS, "sjis"},
+		{"日本語\n", unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), "utf-16le"},
+	}
+	for _, test := range tests {
+		b := []byte(test.text)
+		if test.enc != nil {
+			var err error
+			b, err = test.enc.NewEncoder().Bytes(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		d := Detect(b, DefaultEncodings)
+		if d.Encoding != test.want {
+			t.Errorf("%q in %s: want %s but %s", test.text, test.want, test.want, d)
+		}
+		if d.Confidence <= 0 || d.Confidence > 1 {
+			t.Errorf("%q in %s: invalid confidence %v", test.text, test.want, d.Confidence)
+		}
+	}
+}
+
+func TestDetectPresets(t *testing.T) {
+	tests := []struct {
+		text   string
+		enc    encoding.Encoding
+		preset string
+		want   string
+	}{
+		{"中文的文本，简体字\n", simplifiedchinese.GB18030, "@cjk", "gb18030"},
+		{"中文的文本，繁體字\n", traditionalchinese.Big5, "@cjk", "big5"},
+		{"한국어 텍스트입니다\n", korean.EUCKR, "@cjk", "euc-kr"},
+		{"日本語のテキスト\n", japanese.EUCJP, "@cjk", "euc-jp"},
+		{"日本語のテキスト\n", japanese.ShiftJIS, "@cjk", "sjis"},
+		{"日本語\n", utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM), "@cjk", "utf-32le"},
+		{"Café crème brûlée\n", charmap.Windows1252, "@western", "windows-1252"},
+		{"Café crème brûlée\n", nil, "@western", "utf-8"},
+	}
+	for _, test := range tests {
+		b := []byte(test.text)
+		if test.enc != nil {
+			var err error
+			b, err = test.enc.NewEncoder().Bytes(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		encs, err := expandEncodings([]string{test.preset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := Detect(b, encs)
+		if d.Encoding != test.want {
+			t.Errorf("%q in %s: want %s but %s", test.text, test.want, test.want, d)
+		}
+	}
+}
+
+func TestDetectGB18030Alias(t *testing.T) {
+	// the four-byte sequences are only valid in GB18030, not in GBK
+	b, err := simplifiedchinese.GB18030.NewEncoder().Bytes([]byte("中文 😀\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := Detect(b, []string{"GB18030"}); len(d.Encodings()) != 1 {
+		t.Errorf("want GB18030 valid but %s", d)
+	}
+}
+
+func TestExpandEncodings(t *testing.T) {
+	encs, err := expandEncodings([]string{"utf-8", "@western", "sjis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "utf-8,windows-1252,utf-16le,utf-16be,sjis"
+	if got := strings.Join(encs, ","); got != want {
+		t.Errorf("want %s but %s", want, got)
+	}
+	for _, enc := range []string{"@foo", "no-such-encoding"} {
+		if _, err := expandEncodings([]string{enc}); err == nil {
+			t.Errorf("%s: want error", enc)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package grep
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// encodingRule gives the encoding of the files matching a glob.
+type encodingRule struct {
+	re  *regexp.Regexp
+	enc string
+}
+
+// parseEncodingRule parses a rule of the form GLOB=ENC.
+func parseEncodingRule(rule string) (encodingRule, error) {
+	i := strings.LastIndexByte(rule, '=')
+	if i <= 0 || i == len(rule)-1 {
+		return encodingRule{}, fmt.Errorf("invalid encoding rule: %s", rule)
+	}
+	enc := strings.ToLower(strings.TrimSpace(rule[i+1:]))
+	if e, _ := lookupEncoding(enc); e == nil {
+		return encodingRule{}, fmt.Errorf("unknown encoding: %s", enc)
+	}
+	re, err := compileGlob(strings.TrimSpace(rule[:i]))
+	if err != nil {
+		return encodingRule{}, err
+	}
+	return encodingRule{re: re, enc: enc}, nil
+}
+
+// compileGlob compiles a glob of .editorconfig to a regexp matching slashed
+// paths. A glob without a slash matches the base name in any directory.
+// It knows *, **, ?, [name], [!name], {s1,s2} and {n1..n2}.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	prefix := ""
+	if !strings.Contains(glob, "/") {
+		prefix = "(?:.*/)?"
+	}
+	glob = strings.TrimPrefix(glob, "/")
+	return regexp.Compile("^" + prefix + globToRegexp(glob) + "$")
+}
+
+var numRangeRe = regexp.MustCompile(`^([+-]?\d+)\.\.([+-]?\d+)$`)
+
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				break
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '{':
+			end := matchingBrace(glob, i)
+			if end < 0 {
+				sb.WriteString(`\{`)
+				break
+			}
+			sb.WriteString(braceToRegexp(glob[i+1 : end]))
+			i = end
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return sb.String()
+}
+
+// matchingBrace returns the index of the brace closing the one at i, or -1.
+func matchingBrace(glob string, i int) int {
+	depth := 0
+	for ; i < len(glob); i++ {
+		switch glob[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func braceToRegexp(inner string) string {
+	if m := numRangeRe.FindStringSubmatch(inner); m != nil {
+		lo, _ := strconv.Atoi(m[1])
+		hi, _ := strconv.Atoi(m[2])
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if hi-lo > 10000 {
+			return `[+-]?\d+`
+		}
+		var alts []string
+		for n := lo; n <= hi; n++ {
+			alts = append(alts, strconv.Itoa(n))
+		}
+		return "(?:" + strings.Join(alts, "|") + ")"
+	}
+	var alts []string
+	depth, start := 0, 0
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alts = append(alts, globToRegexp(inner[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if alts == nil {
+		return `\{` + globToRegexp(inner) + `\}`
+	}
+	alts = append(alts, globToRegexp(inner[start:]))
+	return "(?:" + strings.Join(alts, "|") + ")"
+}
+
+// editorConfig is the part of an .editorconfig file used for searching.
+type editorConfig struct {
+	root     bool
+	sections []encodingRule // sections with charset; an empty enc unsets it
+}
+
+// parseEditorConfig reads the charset of each section in the .editorconfig
+// file at path.
+func parseEditorConfig(path string) (*editorConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ec := &editorConfig{}
+	var re *regexp.Regexp
+	inSection := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			inSection = true
+			// a broken glob disables its section
+			re, _ = compileGlob(line[1 : len(line)-1])
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.ToLower(strings.TrimSpace(line[i+1:]))
+		switch {
+		case !inSection && key == "root":
+			ec.root = value == "true"
+		case inSection && re != nil && key == "charset":
+			switch value {
+			case "unset":
+				value = ""
+			case "utf-8-bom":
+				value = "utf-8"
+			default:
+				if e, _ := lookupEncoding(value); e == nil {
+					continue
+				}
+			}
+			ec.sections = append(ec.sections, encodingRule{re: re, enc: value})
+		}
+	}
+	return ec, sc.Err()
+}
+
+type editorConfigChecker struct {
+	dir string
+	ec  *editorConfig
+}
+
+// editorConfigManager finds the charset of files in the .editorconfig files
+// of their directories and the ancestors, up to the one with root = true.
+type editorConfigManager struct {
+	configs sync.Map // dir path -> *editorConfig (or nil)
+	chains  sync.Map // dir path -> []editorConfigChecker (cached ancestor chain)
+}
+
+func (m *editorConfigManager) loadEditorConfig(dir string) *editorConfig {
+	if v, ok := m.configs.Load(dir); ok {
+		ec, _ := v.(*editorConfig)
+		return ec
+	}
+	ec, err := parseEditorConfig(dir + "/.editorconfig")
+	if err != nil {
+		m.configs.Store(dir, (*editorConfig)(nil))
+		return nil
+	}
+	m.configs.Store(dir, ec)
+	return ec
+}
+
+func (m *editorConfigManager) getChain(dir string) []editorConfigChecker {
+	if v, ok := m.chains.Load(dir); ok {
+		return v.([]editorConfigChecker)
+	}
+	var chain []editorConfigChecker
+	for d := dir; ; d = filepath.Dir(d) {
+		ec := m.loadEditorConfig(d)
+		if ec != nil {
+			chain = append(chain, editorConfigChecker{dir: d, ec: ec})
+		}
+		if (ec != nil && ec.root) || d == filepath.Dir(d) {
+			break
+		}
+	}
+	// the nearest file takes precedence, so check it last
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	m.chains.Store(dir, chain)
+	return chain
+}
+
+// charset returns the charset given for the file at absPath, or "".
+func (m *editorConfigManager) charset(absPath string) string {
+	enc := ""
+	for _, c := range m.getChain(filepath.Dir(absPath)) {
+		rel, err := filepath.Rel(c.dir, absPath)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, sec := range c.ec.sections {
+			if sec.re.MatchString(rel) {
+				enc = sec.enc
+			}
+		}
+	}
+	return enc
+}
+
+// charsetOf returns the encoding given for the file at path by
+// Options.EncodingRules or .editorconfig files, or "" if there is none.
+// The globs of the rules match the path relative to the current directory.
+func (s *Searcher) charsetOf(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.cwd, path)
+	}
+	if len(s.rules) > 0 {
+		rel, err := filepath.Rel(s.cwd, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = path
+		}
+		rel = filepath.ToSlash(rel)
+		for i := len(s.rules) - 1; i >= 0; i-- {
+			if s.rules[i].re.MatchString(rel) {
+				return s.rules[i].enc
+			}
+		}
+	}
+	if s.editorConfig == nil {
+		return ""
+	}
+	return s.editorConfig.charset(path)
+}
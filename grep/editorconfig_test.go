@@ -0,0 +1,100 @@
+package grep
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"*.txt", "a.txt", true},
+		{"*.txt", "sub/dir/a.txt", true},
+		{"*.txt", "a.txt.bak", false},
+		{"legacy/*.txt", "legacy/a.txt", true},
+		{"legacy/*.txt", "legacy/sub/a.txt", false},
+		{"/legacy/**", "legacy/sub/a.txt", true},
+		{"legacy/**", "src/legacy/a.txt", false},
+		{"*.{c,h}", "src/a.h", true},
+		{"*.{c,h}", "src/a.go", false},
+		{"{a}.txt", "{a}.txt", true},
+		{"file{1..3}.txt", "file2.txt", true},
+		{"file{1..3}.txt", "file4.txt", false},
+		{"[!a]?.txt", "bc.txt", true},
+		{"[!a]?.txt", "ac.txt", false},
+		{`\*.txt`, "*.txt", true},
+		{`\*.txt`, "a.txt", false},
+	}
+	for _, test := range tests {
+		re, err := compileGlob(test.glob)
+		if err != nil {
+			t.Errorf("%s: %v", test.glob, err)
+			continue
+		}
+		if got := re.MatchString(test.path); got != test.match {
+			t.Errorf("%s against %s: want %v but %v", test.glob, test.path, test.match, got)
+		}
+	}
+}
+
+func TestSearchEditorConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".editorconfig":        "root = true\n\n[*]\ncharset = latin1\n\n[{legacy,old}/**]\ncharset = euc-jp\n",
+		"legacy/.editorconfig": "[sjis/*.txt]\ncharset = shift_jis\n",
+		// "これ" in EUC-JP and Shift_JIS
+		"legacy/a.txt":      "\xa4\xb3\xa4\xec\n",
+		"legacy/sjis/b.txt": "\x82\xb1\x82\xea\n",
+		"old/c.txt":         "\xa4\xb3\xa4\xec\n",
+		"new/d.txt":         "\xa4\xb3\xa4\xec\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(opts *Options) string {
+		var found []string
+		opts.Pattern = "これ"
+		opts.Recursive = true
+		opts.Sink = SinkFunc(func(m *Match) {
+			rel, _ := filepath.Rel(dir, m.Path)
+			found = append(found, filepath.ToSlash(rel)+":"+m.Encoding)
+		})
+		s, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(context.Background(), []string{dir}); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(found)
+		return strings.Join(found, " ")
+	}
+
+	// new/d.txt is in latin1, so it has no match
+	want := "legacy/a.txt:euc-jp legacy/sjis/b.txt:shift_jis old/c.txt:euc-jp"
+	if got := search(&Options{EditorConfig: true}); got != want {
+		t.Errorf("want %q but %q", want, got)
+	}
+	// the rules take precedence over .editorconfig, and the last one wins
+	want = "legacy/a.txt:euc-jp legacy/sjis/b.txt:sjis new/d.txt:euc-jp old/c.txt:euc-jp"
+	if got := search(&Options{EditorConfig: true, EncodingRules: []string{"*.txt=euc-jp", "b.txt=sjis"}}); got != want {
+		t.Errorf("want %q but %q", want, got)
+	}
+	if _, err := New(&Options{Pattern: "x", EncodingRules: []string{"*.txt=nothing"}}); err == nil {
+		t.Error("want error for an unknown encoding")
+	}
+}
@@ -0,0 +1,184 @@
+package grep
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"golang.org/x/text/transform"
+)
+
+// EncodingPresets are the named lists of encodings that may be given in
+// Options.Encodings in place of an encoding.
+var EncodingPresets = map[string][]string{
+	"@ja": {
+		"iso-2022-jp", "euc-jp", "utf-8", "sjis", "utf-16le", "utf-16be",
+	},
+	"@cjk": {
+		"utf-8", "iso-2022-jp", "euc-jp", "sjis", "gb18030", "big5", "euc-kr",
+		"utf-16le", "utf-16be", "utf-32le", "utf-32be",
+	},
+	"@western": {
+		"utf-8", "windows-1252", "utf-16le", "utf-16be",
+	},
+}
+
+// Presets returns the names of the encoding presets.
+func Presets() []string {
+	var names []string
+	for name := range EncodingPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var utf32Encodings = map[string]encoding.Encoding{
+	"utf-32":   utf32.UTF32(utf32.BigEndian, utf32.UseBOM),
+	"utf-32be": utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM),
+	"utf-32le": utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM),
+}
+
+// lookupEncoding returns the encoding of the name enc and its canonical
+// name. It knows the encodings of charset.Lookup and UTF-32.
+func lookupEncoding(enc string) (encoding.Encoding, string) {
+	if e, ok := utf32Encodings[enc]; ok {
+		return e, enc
+	}
+	return charset.Lookup(enc)
+}
+
+// expandEncodings expands the presets in encodings and checks that every
+// encoding is known. The empty name stands for UTF-8 searched as is.
+func expandEncodings(encodings []string) ([]string, error) {
+	var encs []string
+	seen := map[string]bool{}
+	for _, enc := range encodings {
+		enc = strings.TrimSpace(enc)
+		names := []string{enc}
+		if strings.HasPrefix(enc, "@") {
+			var ok bool
+			if names, ok = EncodingPresets[enc]; !ok {
+				return nil, fmt.Errorf("unknown encoding preset: %s", enc)
+			}
+		} else if enc != "" {
+			if e, _ := lookupEncoding(enc); e == nil {
+				return nil, fmt.Errorf("unknown encoding: %s", enc)
+			}
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				encs = append(encs, name)
+			}
+		}
+	}
+	return encs, nil
+}
+
+// canonicalEncoding returns the canonical name of the encoding enc. The empty
+// name is taken as UTF-8.
+func canonicalEncoding(enc string) string {
+	if enc == "" {
+		return "utf-8"
+	}
+	if _, name := lookupEncoding(enc); name != "" {
+		return name
+	}
+	return enc
+}
+
+// asciiCompatible reports whether the ASCII characters are written as ASCII
+// bytes in the encoding enc.
+func asciiCompatible(enc string) bool {
+	enc = canonicalEncoding(enc)
+	return !strings.HasPrefix(enc, "utf-16") && !strings.HasPrefix(enc, "utf-32")
+}
+
+// codeUnitSize returns the size in bytes of the code units of UTF-16 and
+// UTF-32, or 1 for the other encodings.
+func codeUnitSize(enc string) int {
+	enc = canonicalEncoding(enc)
+	switch {
+	case strings.HasPrefix(enc, "utf-16"):
+		return 2
+	case strings.HasPrefix(enc, "utf-32"):
+		return 4
+	}
+	return 1
+}
+
+// encodeNoFlush encodes text with e, leaving out the bytes a stateful
+// encoding writes at the end to return to its initial state.
+func encodeNoFlush(e encoding.Encoding, text []byte) []byte {
+	dst := make([]byte, 4*len(text)+16)
+	n, _, err := e.NewEncoder().Transform(dst, text, false)
+	if err != nil && err != transform.ErrShortSrc {
+		return nil
+	}
+	return dst[:n]
+}
+
+// rawLines looks up the lines of a file in its source bytes for
+// Options.RawOutput. The lines must be looked up in order.
+type rawLines struct {
+	b    []byte
+	e    encoding.Encoding
+	nl   []byte // newline in the encoding
+	cr   []byte // carriage return in the encoding
+	line int    // number of the line before pos
+	pos  int
+}
+
+func newRawLines(b []byte, e encoding.Encoding, lineBase int) *rawLines {
+	return &rawLines{
+		b:    b,
+		e:    e,
+		nl:   encodeNoFlush(e, []byte{'\n'}),
+		cr:   encodeNoFlush(e, []byte{'\r'}),
+		line: lineBase,
+	}
+}
+
+// next returns the position of the newline from pos, or the length of b.
+func (r *rawLines) next() int {
+	for i := r.pos; i < len(r.b); {
+		n := bytes.Index(r.b[i:], r.nl)
+		if n < 0 {
+			break
+		}
+		// the newline must be a whole code unit
+		if i += n; i%len(r.nl) == 0 {
+			return i
+		}
+		i++
+	}
+	return len(r.b)
+}
+
+// apply replaces the decoded text of m with the source bytes of its line,
+// and moves its submatches to the source bytes.
+func (r *rawLines) apply(m *Match) {
+	for r.line+1 < m.Line && r.pos < len(r.b) {
+		r.pos = r.next() + len(r.nl)
+		r.line++
+	}
+	text := r.b[r.pos:r.next()]
+	if bytes.HasSuffix(text, r.cr) && len(text)%len(r.cr) == 0 {
+		text = text[:len(text)-len(r.cr)]
+	}
+	for _, mm := range m.Submatches {
+		mm[0], mm[1] = len(encodeNoFlush(r.e, m.Text[:mm[0]])), len(encodeNoFlush(r.e, m.Text[:mm[1]]))
+		if mm[1] > len(text) {
+			mm[0], mm[1] = len(text), len(text)
+		}
+	}
+	if len(m.Submatches) > 0 {
+		m.Column = m.Submatches[0][0] + 1
+	}
+	m.Text = append(m.Text[:0], text...)
+}
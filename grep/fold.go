@@ -0,0 +1,395 @@
+package grep
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// A folder rewrites text so that the characters it takes as equal are
+// written the same, like full-width and half-width forms.
+type folder interface {
+	// next folds the characters at the start of b. It returns the number
+	// of bytes read, and their folded form, or nil if they are kept as is.
+	next(b []byte) (n int, folded []byte)
+
+	// runes returns the characters folded to another single character,
+	// mapped to it. It is used to fold character classes.
+	runes() map[rune]rune
+}
+
+// folding reports whether the options fold the text before matching.
+func (o *Options) folding() bool {
+	return o.FoldWidth || o.FoldKana || o.FoldKanjiVariants || o.Normalize != ""
+}
+
+// newFolders returns the folders for the options, in the order they apply.
+func newFolders(o *Options) ([]folder, error) {
+	var folders []folder
+	switch strings.ToLower(o.Normalize) {
+	case "":
+	case "nfc":
+		folders = append(folders, &normFolder{form: norm.NFC})
+	case "nfkc":
+		folders = append(folders, &normFolder{form: norm.NFKC})
+	default:
+		return nil, fmt.Errorf("unknown normalization form: %s", o.Normalize)
+	}
+	if o.FoldWidth {
+		folders = append(folders, widthFolder{})
+	}
+	if o.FoldKana {
+		folders = append(folders, kanaFolder{})
+	}
+	if o.FoldKanjiVariants {
+		t, err := newVariantTable(o.KanjiVariants)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, &variantFolder{table: t})
+	}
+	return folders, nil
+}
+
+// foldSpan is a part of a folded text whose length is not the one of the
+// text it was folded from.
+type foldSpan struct {
+	from, to         int // span in the folded text
+	origFrom, origTo int // span in the original text
+}
+
+// foldSpans maps the positions in a folded text back to the original text.
+type foldSpans []foldSpan
+
+// start maps the start of a match at p.
+func (spans foldSpans) start(p int) int {
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].from > p }) - 1
+	if i < 0 {
+		return p
+	}
+	sp := spans[i]
+	if p < sp.to {
+		return sp.origFrom
+	}
+	return sp.origTo + p - sp.to
+}
+
+// end maps the end of a match at p.
+func (spans foldSpans) end(p int) int {
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].from >= p }) - 1
+	if i < 0 {
+		return p
+	}
+	sp := spans[i]
+	if p < sp.to {
+		return sp.origTo
+	}
+	return sp.origTo + p - sp.to
+}
+
+// foldText folds b with f. It returns b itself if nothing is folded.
+func foldText(f folder, b []byte) ([]byte, foldSpans) {
+	var out []byte
+	var spans foldSpans
+	last := 0 // end of the text of b written to out
+	for i := 0; i < len(b); {
+		n, folded := f.next(b[i:])
+		if folded != nil {
+			if out == nil {
+				out = make([]byte, 0, len(b))
+			}
+			out = append(out, b[last:i]...)
+			if len(folded) != n {
+				spans = append(spans, foldSpan{len(out), len(out) + len(folded), i, i + n})
+			}
+			out = append(out, folded...)
+			last = i + n
+		}
+		i += n
+	}
+	if out == nil {
+		return b, nil
+	}
+	return append(out, b[last:]...), spans
+}
+
+// foldString folds s with all the folders.
+func foldString(folders []folder, s string) string {
+	b := []byte(s)
+	for _, f := range folders {
+		b, _ = foldText(f, b)
+	}
+	return string(b)
+}
+
+// foldMatcher matches the lines folded by its folders, and reports the
+// matches in the original lines.
+type foldMatcher struct {
+	m       Matcher
+	folders []folder
+}
+
+func (m *foldMatcher) fold(line []byte) ([]byte, []foldSpans) {
+	var maps []foldSpans
+	for i, f := range m.folders {
+		var spans foldSpans
+		line, spans = foldText(f, line)
+		if spans != nil {
+			if maps == nil {
+				maps = make([]foldSpans, len(m.folders))
+			}
+			maps[i] = spans
+		}
+	}
+	return line, maps
+}
+
+func unfold(maps []foldSpans, loc []int) []int {
+	for i := len(maps) - 1; i >= 0; i-- {
+		loc[0], loc[1] = maps[i].start(loc[0]), maps[i].end(loc[1])
+	}
+	return loc
+}
+
+func (m *foldMatcher) Find(line []byte) []int {
+	folded, maps := m.fold(line)
+	loc := m.m.Find(folded)
+	if loc == nil {
+		return nil
+	}
+	return unfold(maps, loc)
+}
+
+func (m *foldMatcher) FindAll(line []byte) [][]int {
+	folded, maps := m.fold(line)
+	locs := m.m.FindAll(folded)
+	for _, loc := range locs {
+		unfold(maps, loc)
+	}
+	return locs
+}
+
+// Literal reports whether the folded pattern is a fixed string. The folders
+// never fold across lines, so this is the same as for the inner matcher.
+func (m *foldMatcher) Literal() bool {
+	return m.m.Literal()
+}
+
+// foldPattern returns the pattern of opts with its literals folded.
+func foldPattern(opts *Options, folders []folder) (string, error) {
+	if opts.Fixed {
+		return foldString(folders, opts.Pattern), nil
+	}
+	re, err := syntax.Parse(opts.Pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	foldRegexp(re, folders)
+	return re.String(), nil
+}
+
+// foldRegexp folds the literals and the character classes of re in place.
+func foldRegexp(re *syntax.Regexp, folders []folder) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		re.Rune = []rune(foldString(folders, string(re.Rune)))
+	case syntax.OpCharClass:
+		for _, f := range folders {
+			re.Rune = foldClass(re.Rune, f.runes())
+		}
+	}
+	for _, sub := range re.Sub {
+		foldRegexp(sub, folders)
+	}
+}
+
+// foldClass adds to the class of ranges class the folded forms of its
+// characters, with fold mapping the characters to their folded forms. A
+// negated class, which reaches unicode.MaxRune, is folded as the class it
+// negates, so that [^ ] does not match the space folded from "\u3000".
+func foldClass(class []rune, fold map[rune]rune) []rune {
+	if n := len(class); n > 0 && class[n-1] == unicode.MaxRune {
+		return negateClass(foldClass(negateClass(class), fold))
+	}
+	out := append([]rune(nil), class...)
+	for r, c := range fold {
+		for i := 0; i+1 < len(class); i += 2 {
+			if class[i] <= r && r <= class[i+1] {
+				out = append(out, c, c)
+				break
+			}
+		}
+	}
+	return mergeRanges(out)
+}
+
+// negateClass returns the class of ranges matching the characters that the
+// sorted class of ranges class does not.
+func negateClass(class []rune) []rune {
+	var out []rune
+	next := rune(0)
+	for i := 0; i+1 < len(class); i += 2 {
+		if class[i] > next {
+			out = append(out, next, class[i]-1)
+		}
+		next = class[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		out = append(out, next, unicode.MaxRune)
+	}
+	return out
+}
+
+// mergeRanges sorts the class of ranges class and merges the ranges that
+// overlap or touch.
+func mergeRanges(class []rune) []rune {
+	type rng struct{ lo, hi rune }
+	ranges := make([]rng, 0, len(class)/2)
+	for i := 0; i+1 < len(class); i += 2 {
+		ranges = append(ranges, rng{class[i], class[i+1]})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].lo < ranges[j].lo })
+	out := class[:0]
+	for _, r := range ranges {
+		if n := len(out); n > 0 && r.lo <= out[n-1]+1 {
+			if r.hi > out[n-1] {
+				out[n-1] = r.hi
+			}
+			continue
+		}
+		out = append(out, r.lo, r.hi)
+	}
+	return out
+}
+
+// widthFolder folds full-width ASCII to ASCII and half-width katakana to
+// full-width, joining the half-width voiced sound marks to the kana before
+// them, as in the "canonical width" of golang.org/x/text/width.
+type widthFolder struct{}
+
+func (widthFolder) next(b []byte) (int, []byte) {
+	if b[0] < utf8.RuneSelf {
+		return 1, nil
+	}
+	r, size := utf8.DecodeRune(b)
+	if 0xff61 <= r && r <= 0xff9f && len(b) >= size+3 {
+		// ﾞ and ﾟ are U+FF9E and U+FF9F
+		if m, n := utf8.DecodeRune(b[size:]); m == 0xff9e || m == 0xff9f {
+			// width.Fold leaves them as combining marks
+			if folded := norm.NFC.Bytes(width.Fold.Bytes(b[:size+n])); utf8.RuneCount(folded) == 1 {
+				return size + n, folded
+			}
+		}
+	}
+	if f := width.LookupRune(r).Folded(); f != 0 {
+		return size, []byte(string(f))
+	}
+	return size, nil
+}
+
+var (
+	widthRunesOnce sync.Once
+	widthRunes     map[rune]rune
+)
+
+func (widthFolder) runes() map[rune]rune {
+	widthRunesOnce.Do(func() {
+		widthRunes = map[rune]rune{}
+		for r := rune(0x80); r <= 0xffff; r++ {
+			if f := width.LookupRune(r).Folded(); f != 0 {
+				widthRunes[r] = f
+			}
+		}
+	})
+	return widthRunes
+}
+
+// kanaFolder folds katakana to hiragana. The katakana without a hiragana
+// form, like ヷ, are kept as is.
+type kanaFolder struct{}
+
+// kanaRune returns the hiragana for the katakana r, or 0.
+func kanaRune(r rune) rune {
+	switch {
+	case 'ァ' <= r && r <= 'ヶ', r == 'ヽ' || r == 'ヾ':
+		return r - ('ァ' - 'ぁ')
+	}
+	return 0
+}
+
+func (kanaFolder) next(b []byte) (int, []byte) {
+	// the katakana are from U+30A1 to U+30FE, starting with 0xe3 0x82 or
+	// 0xe3 0x83 in UTF-8
+	if b[0] != 0xe3 || len(b) < 3 || (b[1] != 0x82 && b[1] != 0x83) {
+		return 1, nil
+	}
+	r, size := utf8.DecodeRune(b)
+	if h := kanaRune(r); h != 0 {
+		return size, []byte(string(h))
+	}
+	return size, nil
+}
+
+var (
+	kanaRunesOnce sync.Once
+	kanaRunes     map[rune]rune
+)
+
+func (kanaFolder) runes() map[rune]rune {
+	kanaRunesOnce.Do(func() {
+		kanaRunes = map[rune]rune{}
+		for r := 'ァ'; r <= 'ヾ'; r++ {
+			if h := kanaRune(r); h != 0 {
+				kanaRunes[r] = h
+			}
+		}
+	})
+	return kanaRunes
+}
+
+// normFolder folds text to a Unicode normalization form.
+type normFolder struct {
+	form norm.Form
+	once sync.Once
+	fold map[rune]rune
+}
+
+func (f *normFolder) next(b []byte) (int, []byte) {
+	// an ASCII character followed by another is normal on its own
+	if b[0] < utf8.RuneSelf && (len(b) == 1 || b[1] < utf8.RuneSelf) {
+		return 1, nil
+	}
+	n := f.form.NextBoundary(b, true)
+	if n <= 0 {
+		n = len(b)
+	}
+	if f.form.IsNormal(b[:n]) {
+		return n, nil
+	}
+	return n, f.form.Bytes(b[:n])
+}
+
+func (f *normFolder) runes() map[rune]rune {
+	f.once.Do(func() {
+		f.fold = map[rune]rune{}
+		var buf [utf8.UTFMax]byte
+		for r := rune(0x80); r <= unicode.MaxRune; r++ {
+			n := utf8.EncodeRune(buf[:], r)
+			if f.form.Properties(buf[:n]).Decomposition() == nil {
+				continue
+			}
+			folded := f.form.Bytes(buf[:n])
+			if c, size := utf8.DecodeRune(folded); size == len(folded) && c != r {
+				f.fold[r] = c
+			}
+		}
+	})
+	return f.fold
+}
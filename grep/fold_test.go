@@ -0,0 +1,149 @@
+package grep
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFoldMatchers(t *testing.T) {
+	tests := []struct {
+		opts Options
+		line string
+		want [][]int
+	}{
+		{Options{Pattern: "ABC", FoldWidth: true}, "ＡＢＣ ABC", [][]int{{0, 9}, {10, 13}}},
+		{Options{Pattern: "ＡＢＣ", Fixed: true, FoldWidth: true}, "xABC", [][]int{{1, 4}}},
+		{Options{Pattern: "abc", Fixed: true, IgnoreCase: true, FoldWidth: true}, "ＡＢＣ", [][]int{{0, 9}}},
+		{Options{Pattern: "[0-9]+", FoldWidth: true}, "a１２3", [][]int{{1, 8}}},
+		{Options{Pattern: "カタカナ", FoldWidth: true}, "ｶﾀｶﾅ", [][]int{{0, 12}}},
+		{Options{Pattern: "ガッコウ", FoldWidth: true}, "ｶﾀｶﾅ ｶﾞｯｺｳ", [][]int{{13, 28}}},
+		{Options{Pattern: "ｶﾞ", FoldWidth: true}, "ｶﾞガ", [][]int{{0, 6}, {6, 9}}},
+		{Options{Pattern: "[^a ]+", FoldWidth: true}, "ｂ　ｃ", [][]int{{0, 3}, {6, 9}}},
+		{Options{Pattern: "[Ａ-Ｃ]", FoldWidth: true}, "zB", [][]int{{1, 2}}},
+		{Options{Pattern: "けんさく", FoldKana: true}, "ケンサク けんさく", [][]int{{0, 12}, {13, 25}}},
+		{Options{Pattern: "ケン.く", FoldKana: true}, "けんサク", [][]int{{0, 12}}},
+		{Options{Pattern: "[ア-ン]+", FoldKana: true}, "すシ", [][]int{{0, 6}}},
+		{Options{Pattern: "ヷ", Fixed: true, FoldKana: true}, "わ゙ヷ", [][]int{{6, 9}}},
+		{Options{Pattern: "Kensaku けんさく", Fixed: true, IgnoreCase: true, FoldKana: true}, "KENSAKU ケンサク", [][]int{{0, 20}}},
+		{Options{Pattern: "けんさく", FoldKana: true, FoldWidth: true}, "ｹﾝｻｸ", [][]int{{0, 12}}},
+		{Options{Pattern: "が", Normalize: "nfc"}, "か\u3099が", [][]int{{0, 6}, {6, 9}}},
+		{Options{Pattern: "か\u3099", Fixed: true, Normalize: "nfc"}, "xが", [][]int{{1, 4}}},
+		{Options{Pattern: "[がぎ]+", Normalize: "nfc"}, "き\u3099か\u3099", [][]int{{0, 12}}},
+		{Options{Pattern: "(株)", Fixed: true, Normalize: "nfkc"}, "㈱と(株)", [][]int{{0, 3}, {6, 11}}},
+		{Options{Pattern: "株", Normalize: "nfkc"}, "㈱", [][]int{{0, 3}}},
+		{Options{Pattern: "アイ", Normalize: "nfkc", FoldKana: true}, "ｱい", [][]int{{0, 6}}},
+		{Options{Pattern: "[ア-ン]", Normalize: "nfkc"}, "ｶ", [][]int{{0, 3}}},
+		{Options{Pattern: "高橋", Fixed: true, FoldKanjiVariants: true}, "髙橋と高橋", [][]int{{0, 6}, {9, 15}}},
+		{Options{Pattern: "(斎|齊)藤", FoldKanjiVariants: true}, "齋藤 斉藤", [][]int{{0, 6}, {7, 13}}},
+		{Options{Pattern: "[﨑]", FoldKanjiVariants: true}, "崎", [][]int{{0, 3}}},
+		{Options{Pattern: "吉田", FoldKanjiVariants: true}, "𠮷田", [][]int{{0, 7}}},
+		{Options{Pattern: "学校", FoldKanjiVariants: true}, "學校", [][]int{{0, 6}}},
+		{Options{Pattern: "塚", FoldKanjiVariants: true}, "\ufa10", [][]int{{0, 3}}},
+	}
+	for _, test := range tests {
+		m, ascii, err := compileMatcher(&test.opts, func(...interface{}) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ascii {
+			t.Errorf("%q: want non-ascii matcher", test.opts.Pattern)
+		}
+		got := m.FindAll([]byte(test.line))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q in %q: want %v but %v", test.opts.Pattern, test.line, test.want, got)
+		}
+		if first := m.Find([]byte(test.line)); !reflect.DeepEqual(first, test.want[0]) {
+			t.Errorf("%q in %q: want %v but %v", test.opts.Pattern, test.line, test.want[0], first)
+		}
+	}
+}
+
+func TestNormalizeForm(t *testing.T) {
+	if _, err := New(&Options{Pattern: "x", Normalize: "nfd"}); err == nil {
+		t.Error("want error for an unknown normalization form")
+	}
+}
+
+func TestKanjiVariantsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "variants.txt")
+	// 髙 is in the bundled group of 高, so 鷹 joins it
+	if err := os.WriteFile(file, []byte("# names\n髙鷹\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, _, err := compileMatcher(&Options{Pattern: "高", FoldKanjiVariants: true, KanjiVariants: file}, func(...interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.FindAll([]byte("鷹髙")); !reflect.DeepEqual(got, [][]int{{0, 3}, {3, 6}}) {
+		t.Errorf("want [[0 3] [3 6]] but %v", got)
+	}
+	if _, err := New(&Options{Pattern: "x", FoldKanjiVariants: true, KanjiVariants: file + ".none"}); err == nil {
+		t.Error("want error for a missing file")
+	}
+}
+
+func TestFoldLiteral(t *testing.T) {
+	// folded fixed strings still take the fast path of UTF-8
+	m, _, err := compileMatcher(&Options{Pattern: "ケンサク", Fixed: true, FoldKana: true}, func(...interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Literal() {
+		t.Error("want literal matcher")
+	}
+	if got := m.Find([]byte("けんさく\nケンサク")); !reflect.DeepEqual(got, []int{0, 12}) {
+		t.Errorf("want [0 12] but %v", got)
+	}
+}
+
+func TestSearchFoldWidth(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"utf8.txt": "abc\nｶﾞｯｺｳ ＡＢＣ\n",
+		"sjis.txt": "abc\n\xb6\xde\xaf\xba\xb3 \x82\x60\x82\x61\x82\x62\n", // the same in Shift_JIS
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, encs := range [][]string{{"utf-8"}, {"utf-8", "sjis"}} {
+		var got []Match
+		s, err := New(&Options{
+			Pattern:   "ガッコウ ABC",
+			Fixed:     true,
+			FoldWidth: true,
+			Encodings: encs,
+			Sink:      SinkFunc(func(m *Match) { got = append(got, *m) }),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(context.Background(), []string{filepath.Join(dir, "utf8.txt")}); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Line != 2 || !reflect.DeepEqual(got[0].Submatches, [][]int{{0, 25}}) {
+			t.Errorf("%v: unexpected matches: %+v", encs, got)
+		}
+	}
+
+	var got []Match
+	s, err := New(&Options{
+		Pattern:   "ガッコウ",
+		FoldWidth: true,
+		Only:      true,
+		Sink:      SinkFunc(func(m *Match) { got = append(got, *m) }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(context.Background(), []string{filepath.Join(dir, "sjis.txt")}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Encoding != "sjis" || string(got[0].Text) != "ｶﾞｯｺｳ ＡＢＣ" || !reflect.DeepEqual(got[0].Submatches, [][]int{{0, 15}}) {
+		t.Errorf("unexpected matches: %+v", got)
+	}
+}
@@ -0,0 +1,1231 @@
+// Package grep implements the multi-encoding search engine of jvgrep.
+//
+// A Searcher is built from Options and can be used to search files, directory
+// trees and readers. Searchers do not share any state, so several of them may
+// run at the same time in one process.
+package grep
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/mattn/jvgrep/v5/mmap"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+const (
+	cMAGENTA = "\x1b[35;1m" // Color magenta
+	cCYAN    = "\x1b[36;1m" // Color cyan
+	cGREEN   = "\x1b[32;1m" // Color green
+	cRED     = "\x1b[31;1m" // Color red
+	cRESET   = "\x1b[39;0m" // Color reset
+)
+
+// ColorReset is the escape sequence that resets the terminal color.
+const ColorReset = cRESET
+
+// DefaultEncodings is the list of encodings tried when Options.Encodings is
+// empty.
+var DefaultEncodings = []string{
+	"iso-2022-jp",
+	"euc-jp",
+	"utf-8",
+	"sjis",
+	"utf-16le",
+	"utf-16be",
+}
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16BE = []byte{0xfe, 0xff}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF32BE = []byte{0x00, 0x00, 0xfe, 0xff}
+	bomUTF32LE = []byte{0xff, 0xfe, 0x00, 0x00}
+)
+
+// boms maps the byte order marks to the encodings they stand for. The empty
+// encoding searches UTF-8 as is. UTF-32LE must come before UTF-16LE.
+var boms = []struct {
+	bom []byte
+	enc string
+}{
+	{bomUTF32BE, "utf-32be"},
+	{bomUTF32LE, "utf-32le"},
+	{bomUTF8, ""},
+	{bomUTF16BE, "utf-16be"},
+	{bomUTF16LE, "utf-16le"},
+}
+
+var replbytes = []byte{0xef, 0xbf, 0xbd} // bytes representation of the replacement rune '�'
+
+// Options controls the behavior of a Searcher.
+type Options struct {
+	Pattern    string  // pattern to search
+	Fixed      bool    // pattern is a fixed string
+	Perl       bool    // pattern is a perl regexp
+	Basic      bool    // pattern is a basic regexp
+	IgnoreCase bool    // ignore case
+	Matcher    Matcher // matcher used instead of Pattern
+	FoldWidth  bool    // match full-width and half-width forms alike
+	FoldKana   bool    // match hiragana and katakana alike
+	Normalize  string  // normalize the pattern and the lines: "nfc" or "nfkc"
+
+	FoldKanjiVariants bool   // match the variants of kanji alike, like 高 and 髙
+	KanjiVariants     string // file of more groups of kanji variants
+
+	Migemo     bool   // pattern is romaji expanded to kana and the words read so
+	MigemoDict string // path of the dictionary of C/Migemo (default: found in MigemoDictPaths)
+
+	Encodings     []string // encodings of input files (default: DefaultEncodings)
+	EncodingRules []string // rules like "*.txt=sjis" giving the encoding of matching files
+	EditorConfig  bool     // take the encoding of files from charset in .editorconfig
+	MixedEncoding bool     // detect the encoding of each line, not of each file
+	Inventory     bool     // report the encoding of each file instead of searching
+	IgnoreBinary  bool     // ignore binary files
+	Invert        bool     // select non-matching lines
+	Only          bool     // show only matched parts
+	List          bool     // show only names of matched files
+	Number        bool     // show line number
+	MaxCount      int      // stop reading a file after NUM selected lines
+	Limit         int      // stop the search after NUM selected lines in total
+	Count         bool     // show count of matching lines for each file
+	CountTotal    bool     // show only the total count of all files
+	CountMatches  bool     // count matches instead of matching lines
+	Column        bool     // show column
+	After         int      // show after lines
+	Before        int      // show before lines
+
+	Recursive  bool   // recursive search
+	Exclude    string // exclude pattern (default: DefaultExclude)
+	GitIgnore  bool   // respect .gitignore files
+	SkipHidden bool   // skip hidden files/directories
+
+	Relative     bool   // print relative path
+	Separator    string // column separator (default: ":")
+	ZeroFile     bool   // write \0 after the filename
+	ZeroData     bool   // write \0 after the match
+	Color        bool   // colorize output
+	ShowEncoding bool   // show the encoding of the file before each line
+	RawOutput    bool   // print the lines in the encoding of the file
+	Verbose      bool   // verbose output
+	Workers      int    // number of workers (default: GOMAXPROCS)
+	Sort         string // order of files: "none" or "path" (default: "none")
+
+	Sink   Sink      // receiver of results (default: text printer writing to Stdout, finished by each search)
+	Stdout io.Writer // output of results (default: os.Stdout)
+	Stderr io.Writer // output of errors and verbose messages (default: os.Stderr)
+}
+
+// Searcher searches files with the pattern given in Options.
+type Searcher struct {
+	opts    Options
+	matcher Matcher
+	ascii   bool
+	needles map[string]*rawNeedle // required literal of the pattern in each encoding
+	ere     *regexp.Regexp
+	cwd     string
+	sink    Sink
+	printer Printer // default sink, finished by Search and SearchReader
+
+	rules        []encodingRule       // parsed Options.EncodingRules
+	editorConfig *editorConfigManager // nil unless Options.EditorConfig
+
+	mu           sync.Mutex
+	owner        *grepArg   // file streaming to the sink, guarded by mu
+	held         []*grepArg // files done while owner streams, guarded by mu
+	countMatch   int64
+	countFiles   int64
+	countMatched int64
+}
+
+// limiter counts the lines passed to the sink by one search, for
+// Options.Limit.
+type limiter struct {
+	reported int                // lines passed to the sink, guarded by Searcher.mu
+	stop     context.CancelFunc // stops the search when the limit is reached
+}
+
+// grepArg holds the state of searching a file.
+type grepArg struct {
+	ctx      context.Context
+	order    *ordering
+	limiter  *limiter
+	seq      int
+	input    interface{}
+	size     int64
+	single   bool
+	bom      []byte
+	enc      string
+	charset  string // encoding given for the file by a rule
+	output   string
+	lineBase int
+	state    lineState
+	count    int64
+	selected int
+	searched bool
+	file     File
+	matches  []Match
+	stream   bool // results may be passed to the sink before the file is done
+	begun    bool // the file is begun in the sink
+	limited  int  // lines selected counted by the limiter
+}
+
+func (o *Options) setDefaults() {
+	if len(o.Encodings) == 0 {
+		o.Encodings = DefaultEncodings
+	}
+	encodings := make([]string, len(o.Encodings))
+	for i, enc := range o.Encodings {
+		encodings[i] = strings.ToLower(enc)
+	}
+	o.Encodings = encodings
+	if o.CountTotal || o.CountMatches {
+		o.Count = true
+	}
+	if o.MixedEncoding {
+		o.ShowEncoding = true
+	}
+	if o.Separator == "" {
+		o.Separator = ":"
+	}
+	if o.Workers < 1 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.Stdout == nil {
+		o.Stdout = os.Stdout
+	}
+	if o.Stderr == nil {
+		o.Stderr = os.Stderr
+	}
+}
+
+// New returns a Searcher for opts.
+func New(opts *Options) (*Searcher, error) {
+	s := &Searcher{opts: *opts}
+	o := &s.opts
+	o.setDefaults()
+	s.cwd, _ = os.Getwd()
+	var err error
+	if o.Encodings, err = expandEncodings(o.Encodings); err != nil {
+		return nil, err
+	}
+	encodings := o.Encodings
+	for _, rule := range o.EncodingRules {
+		r, err := parseEncodingRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, r)
+		encodings = append(encodings, r.enc)
+	}
+	if o.EditorConfig {
+		s.editorConfig = &editorConfigManager{}
+	}
+	switch o.Sort {
+	case "", "none", "path":
+	default:
+		return nil, fmt.Errorf("unknown sort order: %s", o.Sort)
+	}
+	s.sink = o.Sink
+	if s.sink == nil {
+		if o.Color {
+			s.printer = newColorPrinter(o.Stdout, o)
+		} else {
+			s.printer = newPlainPrinter(o.Stdout, o)
+		}
+		s.sink = s.printer
+	}
+
+	s.matcher = o.Matcher
+	if s.matcher == nil {
+		var err error
+		s.matcher, s.ascii, err = compileMatcher(o, s.debug)
+		if err != nil {
+			return nil, err
+		}
+		if !o.Invert && !o.IgnoreCase && !o.Migemo && !o.folding() {
+			if lit := requiredLiteral(o); lit != "" {
+				s.needles = buildNeedles(lit, encodings)
+			}
+		}
+	}
+
+	if o.Exclude != "" && o.Exclude != DefaultExclude {
+		var err error
+		s.ere, err = regexp.Compile(o.Exclude)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Count returns the number of matches found so far by the current or last
+// search.
+func (s *Searcher) Count() int64 {
+	return atomic.LoadInt64(&s.countMatch)
+}
+
+// Stats returns the totals of the current or last search so far.
+func (s *Searcher) Stats() *Stats {
+	return &Stats{
+		Matches:      s.Count(),
+		Files:        atomic.LoadInt64(&s.countFiles),
+		MatchedFiles: atomic.LoadInt64(&s.countMatched),
+	}
+}
+
+// resetStats resets the totals for a new search.
+func (s *Searcher) resetStats() {
+	atomic.StoreInt64(&s.countMatch, 0)
+	atomic.StoreInt64(&s.countFiles, 0)
+	atomic.StoreInt64(&s.countMatched, 0)
+}
+
+func (s *Searcher) debug(args ...interface{}) {
+	if s.opts.Verbose {
+		fmt.Fprintln(s.opts.Stderr, args...)
+	}
+}
+
+func (s *Searcher) errorLine(str string) {
+	io.WriteString(s.opts.Stderr, str+"\n")
+}
+
+// nulEncoding guesses UTF-16 or UTF-32 without a BOM from the positions of
+// the NUL bytes at the start of b, which are the high bytes of ASCII
+// characters. It returns the canonical name, or "" if b does not look like
+// either.
+func nulEncoding(b []byte) string {
+	if len(b) > 8192 {
+		b = b[:8192]
+	}
+	b = b[:len(b)-len(b)%4]
+	units := len(b) / 4
+	if units < 2 {
+		return ""
+	}
+	var nul [4]int
+	for i, c := range b {
+		if c == 0 {
+			nul[i%4]++
+		}
+	}
+	enc := ""
+	even, odd := nul[0]+nul[2], nul[1]+nul[3]
+	switch {
+	case nul[3] == units && nul[2]*10 >= units*9 && nul[0]*10 <= units:
+		enc = "utf-32le"
+	case nul[0] == units && nul[1]*10 >= units*9 && nul[3]*10 <= units:
+		enc = "utf-32be"
+	// a quarter of the characters or more must be ASCII
+	case odd*2 >= units && even*10 <= odd:
+		enc = "utf-16le"
+	case even*2 >= units && odd*10 <= even:
+		enc = "utf-16be"
+	}
+	if enc != "" && hasControl(b, enc) {
+		return ""
+	}
+	return enc
+}
+
+// hasControl reports whether b in the UTF-16 or UTF-32 encoding enc has
+// control characters other than tabs and line breaks.
+func hasControl(b []byte, enc string) bool {
+	size := codeUnitSize(enc)
+	bigEndian := strings.HasSuffix(enc, "be")
+	for i := 0; i+size <= len(b); i += size {
+		var r rune
+		for j := 0; j < size; j++ {
+			if bigEndian {
+				r = r<<8 | rune(b[i+j])
+			} else {
+				r |= rune(b[i+j]) << (8 * j)
+			}
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+func maybeBinary(b []byte) bool {
+	// Check only the first 8KB, like ripgrep.
+	l := len(b)
+	if l > 8192 {
+		l = 8192
+	}
+	for i := 0; i < l; i++ {
+		if b[i] == 0x00 || (0 < b[i] && b[i] < 0x9) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Searcher) stripUTF8BOM(fb []byte, arg *grepArg) []byte {
+	if len(fb) >= 3 && fb[0] == 0xef && fb[1] == 0xbb && fb[2] == 0xbf {
+		arg.bom = bomUTF8
+		return fb[3:]
+	}
+	arg.bom = nil
+	return fb
+}
+
+func (s *Searcher) doGrepFixedUTF8(path string, fb []byte, arg *grepArg, m Matcher) bool {
+	o := &s.opts
+	if o.IgnoreBinary && maybeBinary(fb) {
+		return false
+	}
+	fb = s.stripUTF8BOM(fb, arg)
+	arg.enc = "utf-8"
+
+	if o.List && !o.Invert {
+		if m.Find(fb) == nil {
+			return false
+		}
+		arg.selected++
+		return true
+	}
+	arg.state = lineState{}
+	return s.grepLines(path, fb, arg, false, m)
+}
+
+// lineState is the state of grepLines carried over from one chunk of a text
+// to the next.
+type lineState struct {
+	lines     int        // number of lines read
+	offset    int        // offset of the chunk in the text
+	prevs     []prevLine // lines kept for the leading context
+	afterLeft int        // number of lines left for the trailing context
+	lastLine  int        // last line number recorded
+	done      bool       // no more lines are needed
+}
+
+// prevLine is a line kept for the leading context.
+type prevLine struct {
+	line   int
+	offset int
+	text   []byte
+	enc    string
+}
+
+// grepLines searches the lines of f and records the results in arg. If raw
+// is true, f is not known to be valid UTF-8. f may be a chunk of a text that
+// ends at a line boundary; arg.state carries the line numbers and context
+// over to the next chunk.
+func (s *Searcher) grepLines(path string, f []byte, arg *grepArg, raw bool, m Matcher) bool {
+	o := &s.opts
+	withContext := (o.Before > 0 || o.After > 0) && !o.Only && !o.Count && !o.List
+	max := s.maxCount()
+	st := &arg.state
+
+	var matched bool
+	lineNo := arg.lineBase + st.lines
+	start := 0
+	size := len(f)
+	defer func() {
+		st.lines = lineNo - arg.lineBase
+		st.offset += size
+		// the next chunk may overwrite f
+		for i := range st.prevs {
+			st.prevs[i].text = append([]byte(nil), st.prevs[i].text...)
+		}
+	}()
+
+	for start < size {
+		if lineNo&1023 == 0 && arg.ctx.Err() != nil {
+			st.done = true
+			break
+		}
+		if max > 0 && arg.selected >= max && st.afterLeft == 0 {
+			st.done = true
+			break
+		}
+		end := size
+		if off := bytes.IndexByte(f[start:], '\n'); off >= 0 {
+			end = start + off
+		}
+		lineNo++
+		line := f[start:end]
+		if l := len(line); l > 0 && line[l-1] == '\r' {
+			line = line[:l-1]
+		}
+		lineStart := start
+		start = end + 1
+
+		var matches [][]int
+		var hasMatch bool
+		if o.Only {
+			matches = m.FindAll(line)
+			hasMatch = len(matches) > 0
+		} else {
+			hasMatch = m.Find(line) != nil
+		}
+		// skip if not match without invert, or match with invert. Once max
+		// lines are selected, the rest is only read for the trailing context.
+		if hasMatch == o.Invert || (max > 0 && arg.selected >= max) {
+			if !withContext {
+				continue
+			}
+			if st.afterLeft > 0 {
+				st.afterLeft--
+				st.lastLine = lineNo
+				arg.addMatch(lineNo, st.offset+lineStart, line, nil, true)
+			} else if o.Before > 0 {
+				if len(st.prevs) == o.Before {
+					st.prevs = st.prevs[1:]
+				}
+				st.prevs = append(st.prevs, prevLine{lineNo, st.offset + lineStart, line, arg.enc})
+			}
+			continue
+		}
+		s.debug("found("+arg.enc+"):", path)
+		matched = true
+		arg.selected++
+		if o.List {
+			st.done = true
+			return true
+		}
+		n := int64(1)
+		if o.Only || (o.CountMatches && hasMatch) {
+			if matches == nil {
+				matches = m.FindAll(line)
+			}
+			n = int64(len(matches))
+		}
+		atomic.AddInt64(&s.countMatch, n)
+		arg.count += n
+		if o.Count {
+			continue
+		}
+
+		binary := false
+		if o.Only {
+			for _, mm := range matches {
+				part := line[mm[0]:mm[1]]
+				if (o.Color && maybeBinary(part)) || (raw && !utf8.Valid(part)) {
+					binary = true
+				}
+			}
+		} else {
+			binary = (o.Color && maybeBinary(line)) || (raw && !utf8.Valid(line))
+		}
+		if binary {
+			s.errorLine(fmt.Sprintf("matched binary file: %s", path))
+			st.done = true
+			return true
+		}
+
+		for _, p := range st.prevs {
+			if p.line > st.lastLine {
+				arg.addMatch(p.line, p.offset, p.text, nil, true)
+				arg.matches[len(arg.matches)-1].Encoding = p.enc
+			}
+		}
+		st.prevs = st.prevs[:0]
+		if hasMatch && !o.Only {
+			matches = m.FindAll(line)
+		}
+		arg.addMatch(lineNo, st.offset+lineStart, line, matches, false)
+		st.afterLeft = o.After
+		st.lastLine = lineNo
+	}
+	return matched
+}
+
+// prepare strips the BOM of fb into arg.bom and narrows the encodings to
+// try for fb by its BOM, a rule or its NUL bytes. It also reports whether fb
+// looks binary.
+func (s *Searcher) prepare(path string, fb []byte, arg *grepArg) ([]byte, []string, bool) {
+	encs := s.opts.Encodings
+	if arg.charset != "" {
+		s.debug("charset("+arg.charset+"):", path)
+		encs = []string{arg.charset}
+	}
+
+	arg.bom = nil
+	for _, b := range boms {
+		if len(fb) > len(b.bom) && bytes.HasPrefix(fb, b.bom) {
+			arg.bom = b.bom
+			fb = fb[len(b.bom):]
+			encs = []string{b.enc}
+			break
+		}
+	}
+	if len(arg.bom) == 0 && arg.charset == "" {
+		if enc := nulEncoding(fb); enc != "" {
+			for _, e := range encs {
+				if canonicalEncoding(e) == enc {
+					s.debug("nul("+e+"):", path)
+					encs = []string{e}
+					break
+				}
+			}
+		}
+	}
+
+	// NUL bytes are not a sign of binary files in UTF-16 and UTF-32.
+	binary := maybeBinary(fb)
+	if len(encs) == 1 && !asciiCompatible(encs[0]) {
+		binary = false
+	}
+	return fb, encs, binary
+}
+
+func (s *Searcher) doGrep(path string, fb []byte, arg *grepArg) bool {
+	o := &s.opts
+	fb, encs, binary := s.prepare(path, fb, arg)
+	if o.IgnoreBinary && binary {
+		return false
+	}
+
+	if s.matcher.Literal() && len(encs) == 1 && encs[0] == "utf-8" {
+		return s.doGrepFixedUTF8(path, fb, arg, s.matcher)
+	}
+
+	if o.MixedEncoding && len(arg.bom) == 0 && len(encs) > 1 && !binary && !utf8.Valid(fb) {
+		return s.grepMixed(path, fb, arg, encs)
+	}
+
+	// Skip the file without decoding it if the raw bytes cannot have a
+	// match in any of the encodings.
+	var may map[string]bool
+	if s.needles != nil && !binary {
+		may = map[string]bool{}
+		found := false
+		for _, enc := range encs {
+			may[enc] = s.mayMatch(fb, enc)
+			found = found || may[enc]
+		}
+		if !found {
+			s.debug("no match in raw bytes:", path)
+			if len(encs) > 0 {
+				arg.enc = encs[0]
+			}
+			return false
+		}
+	}
+
+	// With several candidates, search only the text decoded in the most
+	// likely encoding. The others are tried only if it fails to decode.
+	detected := false
+	if len(arg.bom) == 0 && len(encs) > 1 && !binary {
+		d := Detect(fb, encs)
+		s.debug("detect("+d.Encoding+"):", path+":", d.String())
+		if e := d.Encodings(); len(e) > 0 {
+			encs = e
+			detected = true
+		}
+	}
+
+	var istext bool
+	for e, enc := range encs {
+		if arg.ctx.Err() != nil {
+			break
+		}
+		if e > 0 && istext && s.ascii && asciiCompatible(enc) {
+			continue
+		}
+		if may != nil && !may[enc] {
+			s.debug("no match in raw bytes("+enc+"):", path)
+			if detected {
+				arg.enc = enc
+				break
+			}
+			continue
+		}
+		s.debug("trying("+enc+"):", path)
+
+		arg.enc = enc
+		arg.state = lineState{}
+		var matched bool
+		if enc == "" {
+			arg.enc = "utf-8"
+			if len(fb) == 0 {
+				continue
+			}
+			matched = s.grepLines(path, fb, arg, true, s.matcher)
+		} else if len(arg.bom) > 0 || !binary {
+			ee, _ := lookupEncoding(enc)
+			if ee == nil {
+				continue
+			}
+			var ok bool
+			matched, ok = s.grepDecoded(path, fb, arg, ee, len(encs) == 1 || detected)
+			if !ok {
+				continue
+			}
+			istext = true
+		} else {
+			istext = true
+			matched = s.grepLines(path, fb, arg, true, s.matcher)
+		}
+
+		if matched {
+			return true
+		}
+		if len(fb) == 0 || detected {
+			break
+		}
+	}
+	return false
+}
+
+// decodeChunkSize is the size of the chunks in which grepDecoded decodes a
+// file. Longer lines grow the chunk.
+var decodeChunkSize = 256 * 1024
+
+// grepDecoded decodes fb with the encoding e and searches the text chunk by
+// chunk as it is decoded, so that the memory used does not depend on the
+// size of fb. Each chunk ends at a line boundary. It reports false for ok if
+// fb is not valid in e, and drops the results recorded for it then. If e is
+// settled, that is no other encoding is to be tried, the results are passed
+// to the sink after each chunk; once they are, the rest of fb is searched
+// even if it is not valid in e, with the invalid bytes replaced.
+func (s *Searcher) grepDecoded(path string, fb []byte, arg *grepArg, e encoding.Encoding, settled bool) (matched, ok bool) {
+	nmatches, count, selected := len(arg.matches), arg.count, arg.selected
+	streamed := false
+	defer func() {
+		if !ok {
+			arg.matches = arg.matches[:nmatches]
+			atomic.AddInt64(&s.countMatch, count-arg.count)
+			arg.count, arg.selected = count, selected
+		}
+	}()
+
+	// drop the incomplete code unit at the end of UTF-16 and UTF-32
+	if n := codeUnitSize(arg.enc); n > 1 {
+		fb = fb[:len(fb)-len(fb)%n]
+	}
+	var raw *rawLines
+	if s.opts.RawOutput {
+		raw = newRawLines(fb, e, arg.lineBase+arg.state.lines)
+	}
+	r := transform.NewReader(bytes.NewReader(fb), e.NewDecoder())
+	buf := make([]byte, decodeChunkSize)
+	n := 0
+	eof := false
+	for !eof && !arg.state.done && arg.ctx.Err() == nil {
+		for n < len(buf) && !eof {
+			m, err := r.Read(buf[n:])
+			n += m
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				s.debug(err.Error())
+				return matched, streamed
+			}
+		}
+		end := n
+		if !eof {
+			end = bytes.LastIndexByte(buf[:n], '\n') + 1
+			if end == 0 {
+				buf = append(buf, make([]byte, len(buf))...)
+				continue
+			}
+		}
+		chunk := buf[:end]
+		if !streamed && bytes.Contains(chunk, replbytes) {
+			return matched, false
+		}
+		prev := len(arg.matches)
+		if s.grepLines(path, chunk, arg, false, s.matcher) {
+			matched = true
+		}
+		if raw != nil {
+			for i := prev; i < len(arg.matches); i++ {
+				raw.apply(&arg.matches[i])
+			}
+		}
+		if settled && arg.stream {
+			streamed = s.stream(arg)
+		}
+		n = copy(buf, buf[end:n])
+	}
+	return matched, true
+}
+
+// grepMixed searches fb line by line in the encoding of each line, for
+// Options.MixedEncoding. Runs of lines in the same encoding are decoded
+// together, and lines of ASCII only join the run they are in.
+func (s *Searcher) grepMixed(path string, fb []byte, arg *grepArg, encs []string) bool {
+	var candidates []string
+	for _, enc := range encs {
+		if enc != "" && asciiCompatible(enc) {
+			candidates = append(candidates, enc)
+		}
+	}
+	var used []string
+	matched := false
+	search := func(run []byte, enc string) {
+		if enc == "" {
+			enc = "utf-8"
+		}
+		arg.enc = enc
+		if !containsString(used, enc) {
+			used = append(used, enc)
+		}
+		s.debug("run("+enc+"):", path+":", strconv.Itoa(arg.state.lines+1))
+		e, _ := lookupEncoding(enc)
+		if canonicalEncoding(enc) == "utf-8" || e == nil {
+			if s.grepLines(path, run, arg, true, s.matcher) {
+				matched = true
+			}
+			return
+		}
+		st := arg.state
+		st.prevs = append([]prevLine(nil), st.prevs...)
+		m, ok := s.grepDecoded(path, run, arg, e, false)
+		if !ok {
+			// search what can be decoded rather than nothing
+			arg.state = st
+			b, _, _ := transform.Bytes(e.NewDecoder(), run)
+			m = s.grepLines(path, b, arg, false, s.matcher)
+		}
+		if m {
+			matched = true
+		}
+	}
+
+	runStart, runEnc := 0, ""
+	for start := 0; start < len(fb) && !arg.state.done; {
+		end := len(fb)
+		if i := bytes.IndexByte(fb[start:], '\n'); i >= 0 {
+			end = start + i + 1
+		}
+		if line := fb[start:end]; !isPlainASCII(line) {
+			enc := lineEncoding(line, candidates, runEnc)
+			if runEnc == "" {
+				runEnc = enc
+			} else if enc != runEnc {
+				search(fb[runStart:start], runEnc)
+				runStart, runEnc = start, enc
+			}
+		}
+		start = end
+	}
+	if runStart < len(fb) && !arg.state.done {
+		search(fb[runStart:], runEnc)
+	}
+	arg.enc = strings.Join(used, "+")
+	return matched
+}
+
+// lineEncoding returns the encoding of line among candidates. It keeps the
+// encoding prev of the lines before if line is valid in it.
+func lineEncoding(line []byte, candidates []string, prev string) string {
+	if utf8.Valid(line) {
+		return "utf-8"
+	}
+	if prev != "" && prev != "utf-8" {
+		if d := Detect(line, []string{prev}); len(d.Encodings()) > 0 {
+			return prev
+		}
+	}
+	if encs := Detect(line, candidates).Encodings(); len(encs) > 0 {
+		return encs[0]
+	}
+	if prev != "" || len(candidates) == 0 {
+		return prev
+	}
+	return candidates[0]
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ordering holds the results of the files searched out of the walk order
+// until every earlier file is done.
+type ordering struct {
+	seq     int              // sequence number of the next file walked
+	next    int              // sequence number of the next file to deliver
+	pending map[int]*grepArg // files done but not delivered yet
+}
+
+// deliver passes the results recorded in arg to the sink. Every file sent to
+// the workers must be delivered, even if it has no results, so that the
+// files after it are not held back in ordered mode.
+func (s *Searcher) deliver(arg *grepArg, matched bool) {
+	arg.file.Encoding = arg.enc
+	arg.file.BOM = arg.bom
+	arg.file.Matched = matched
+	arg.file.Count = arg.count
+	ord := arg.order
+	if ord == nil && !s.hasResults(arg) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ord == nil {
+		s.emit(arg)
+		return
+	}
+	ord.pending[arg.seq] = arg
+	for {
+		a, ok := ord.pending[ord.next]
+		if !ok {
+			break
+		}
+		delete(ord.pending, ord.next)
+		s.emit(a)
+		ord.next++
+	}
+}
+
+// hasResults reports whether arg has anything to pass to the sink. When
+// counting or taking the inventory, every file searched has a result.
+func (s *Searcher) hasResults(arg *grepArg) bool {
+	return arg.begun || arg.file.Matched || len(arg.matches) > 0 || (s.opts.Count && arg.searched) || arg.file.Report != nil
+}
+
+// maxCount returns the number of lines to select in a file at most, or 0 if
+// there is no limit.
+func (s *Searcher) maxCount() int {
+	n := s.opts.MaxCount
+	if l := s.opts.Limit; l > 0 && (n <= 0 || l < n) {
+		n = l
+	}
+	return n
+}
+
+// limit trims the results of arg to the lines left before Options.Limit is
+// reached, and stops the search when it is. It reports false if nothing is
+// left. s.mu must be held.
+func (s *Searcher) limit(arg *grepArg) bool {
+	l := arg.limiter
+	left := s.opts.Limit - l.reported
+	if left <= 0 {
+		return false
+	}
+	n := 0
+	for i := range arg.matches {
+		if arg.matches[i].Context {
+			continue
+		}
+		if n++; n < left {
+			continue
+		}
+		// keep the trailing context of the last line
+		j := i + 1
+		for j < len(arg.matches) && arg.matches[j].Context && arg.matches[j].Line <= arg.matches[i].Line+s.opts.After {
+			j++
+		}
+		arg.matches = arg.matches[:j]
+		break
+	}
+	// the lines before arg.limited were counted when streamed
+	selected := arg.selected - arg.limited
+	arg.limited = arg.selected
+	if selected < left {
+		l.reported += selected
+	} else {
+		l.reported += left
+	}
+	if l.reported >= s.opts.Limit {
+		l.stop()
+	}
+	return true
+}
+
+// emit passes the results of arg to the sink. s.mu must be held.
+func (s *Searcher) emit(arg *grepArg) {
+	if !s.hasResults(arg) {
+		return
+	}
+	if s.owner != nil && s.owner != arg {
+		// the calls for different files must not interleave
+		s.held = append(s.held, arg)
+		return
+	}
+	s.flush(arg)
+	if arg.begun {
+		s.sink.EndFile(&arg.file)
+	}
+	if s.owner == arg {
+		s.owner = nil
+		held := s.held
+		s.held = nil
+		for _, a := range held {
+			s.emit(a)
+		}
+	}
+}
+
+// flush passes the results recorded in arg so far to the sink, beginning the
+// file if it is not begun yet. s.mu must be held.
+func (s *Searcher) flush(arg *grepArg) {
+	if s.opts.Limit > 0 && !s.limit(arg) {
+		arg.matches = arg.matches[:0]
+		return
+	}
+	if !arg.begun {
+		s.sink.BeginFile(&arg.file)
+		arg.begun = true
+	}
+	for i := range arg.matches {
+		s.sink.Match(&arg.matches[i])
+	}
+	arg.matches = arg.matches[:0]
+}
+
+// stream passes the results recorded in arg so far to the sink before the
+// file is done, so that they are not held until then. The file then owns the
+// sink until it is emitted, and the files done meanwhile are held. The
+// results are held instead if another file owns the sink, or in ordered mode
+// until every earlier file is delivered. It reports whether the file is
+// begun in the sink.
+func (s *Searcher) stream(arg *grepArg) bool {
+	if len(arg.matches) == 0 {
+		return arg.begun
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ord := arg.order; ord != nil && ord.next != arg.seq {
+		return false
+	}
+	if s.owner != nil && s.owner != arg {
+		return false
+	}
+	arg.file.Encoding = arg.enc
+	arg.file.BOM = arg.bom
+	arg.file.Matched = true
+	arg.file.Count = arg.count
+	s.flush(arg)
+	if arg.begun {
+		s.owner = arg
+	}
+	return arg.begun
+}
+
+// SearchReader searches r line by line. name is used as the file name in the
+// results. It stops when ctx is done.
+func (s *Searcher) SearchReader(ctx context.Context, name string, r io.Reader) (bool, error) {
+	s.resetStats()
+	arg := s.newGrepArg(name, -1, true)
+	arg.ctx = ctx
+	arg.output = name
+	arg.file.Path = name
+	arg.searched = true
+	atomic.AddInt64(&s.countFiles, 1)
+	matched := false
+	in := bufio.NewReader(r)
+	for {
+		f, _, err := in.ReadLine()
+		if s.doGrep(name, f, arg) {
+			if !matched {
+				// the printers pick the line terminator by the encoding
+				arg.file.Encoding = arg.enc
+				arg.file.BOM = arg.bom
+				s.mu.Lock()
+				s.sink.BeginFile(&arg.file)
+				s.mu.Unlock()
+			}
+			matched = true
+		}
+		if len(arg.matches) > 0 {
+			s.mu.Lock()
+			for i := range arg.matches {
+				s.sink.Match(&arg.matches[i])
+			}
+			s.mu.Unlock()
+			arg.matches = arg.matches[:0]
+		}
+		arg.lineBase++
+		if err != nil || (matched && s.opts.List) || ctx.Err() != nil {
+			break
+		}
+		if max := s.maxCount(); max > 0 && arg.selected >= max {
+			break
+		}
+	}
+	if matched {
+		atomic.AddInt64(&s.countMatched, 1)
+	}
+	if matched || s.opts.Count {
+		arg.file.Encoding = arg.enc
+		arg.file.BOM = arg.bom
+		arg.file.Matched = matched
+		arg.file.Count = arg.count
+		s.mu.Lock()
+		if !matched {
+			s.sink.BeginFile(&arg.file)
+		}
+		s.sink.EndFile(&arg.file)
+		s.mu.Unlock()
+	}
+	s.finish()
+	return matched, ctx.Err()
+}
+
+// finish finishes the default printer, which writes the totals.
+func (s *Searcher) finish() {
+	if s.printer != nil {
+		s.printer.Finish(s.Stats())
+	}
+}
+
+// SearchFile searches the file at path. It stops when ctx is done.
+func (s *Searcher) SearchFile(ctx context.Context, path string) (bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return false, errors.New(path + ": not a regular file")
+	}
+	parent := ctx
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	s.resetStats()
+	arg := s.newGrepArg(path, fi.Size(), false)
+	arg.limiter = &limiter{stop: stop}
+	matched := s.grepFile(ctx, arg)
+	s.finish()
+	return matched, parent.Err()
+}
+
+func (s *Searcher) grepFile(ctx context.Context, arg *grepArg) bool {
+	arg.stream = true
+	matched := s.readAndGrep(ctx, arg)
+	if matched {
+		atomic.AddInt64(&s.countMatched, 1)
+	}
+	// Deliver buffered results under lock
+	s.deliver(arg, matched)
+	return matched
+}
+
+func (s *Searcher) readAndGrep(ctx context.Context, arg *grepArg) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	arg.ctx = ctx
+	path, _ := arg.input.(string)
+	// Read file outside lock for parallel I/O
+	var data []byte
+	var mf *mmap.Memfile
+	var err error
+	if arg.size > 65536*4 {
+		mf, err = mmap.Open(path)
+		if err != nil {
+			s.errorLine(err.Error() + ": " + path)
+			return false
+		}
+		defer mf.Close()
+		data = mf.Data()
+	} else {
+		data, err = os.ReadFile(path)
+		if err != nil {
+			s.errorLine(err.Error() + ": " + path)
+			return false
+		}
+	}
+	// Grep outside lock for parallel matching
+	arg.searched = true
+	atomic.AddInt64(&s.countFiles, 1)
+	arg.charset = s.charsetOf(path)
+	if s.opts.Inventory {
+		arg.file.Report = s.inventory(path, data, arg)
+		return false
+	}
+	return s.doGrep(path, data, arg)
+}
+
+func (s *Searcher) goGrep(ctx context.Context, lim *limiter, ch chan *grepArg, done chan bool) {
+	n := 0
+	for {
+		arg := <-ch
+		if arg == nil {
+			break
+		}
+		arg.limiter = lim
+		// grepFile skips the remaining files once ctx is done
+		if s.grepFile(ctx, arg) {
+			n++
+		}
+	}
+	done <- n > 0
+}
+
+// Search searches the files and directories given as args. Each arg may
+// contain glob patterns. It reports whether any match was found. When ctx is
+// done, the search stops and the results found so far are delivered before
+// Search returns ctx.Err(). Reaching Options.Limit stops the search without
+// an error.
+func (s *Searcher) Search(ctx context.Context, args []string) (bool, error) {
+	parent := ctx
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	lim := &limiter{stop: stop}
+	s.resetStats()
+
+	nworkers := s.opts.Workers
+	ch := make(chan *grepArg, nworkers*2)
+	done := make(chan bool, nworkers)
+	for i := 0; i < nworkers; i++ {
+		go s.goGrep(ctx, lim, ch, done)
+	}
+	var ord *ordering
+	if s.opts.Sort == "path" {
+		ord = &ordering{pending: map[int]*grepArg{}}
+	}
+	err := s.walk(ctx, args, ch, ord)
+	for i := 0; i < nworkers; i++ {
+		ch <- nil
+	}
+	result := false
+	for i := 0; i < nworkers; i++ {
+		if <-done {
+			result = true
+		}
+	}
+	if err == nil || err == ctx.Err() {
+		err = parent.Err()
+	}
+	s.finish()
+	return result, err
+}
+
+func (s *Searcher) buildOutputPath(path string) string {
+	if !s.opts.Relative || path == "" {
+		return path
+	}
+	if fe, err := filepath.Rel(s.cwd, path); err == nil {
+		return fe
+	}
+	return path
+}
+
+func (s *Searcher) newGrepArg(path string, size int64, single bool) *grepArg {
+	output := s.buildOutputPath(path)
+	return &grepArg{
+		input:  path,
+		size:   size,
+		single: single,
+		output: output,
+		file:   File{Path: output, Single: single},
+	}
+}
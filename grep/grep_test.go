@@ -0,0 +1,604 @@
+package grep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIsLiteralRegexp(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		isRegexp bool
+	}{
+		{`foo`, true},
+		{`.foo`, false},
+		{`\foo`, false},
+		{`(foo)`, false},
+		{`/foo/`, true},
+		{`%foo%`, true},
+	}
+
+	for _, test := range tests {
+		value := isLiteralRegexp(test.pattern)
+		expected := test.isRegexp
+		if value != expected {
+			t.Fatalf("Pattern isLiteralRegexp(%v) is %v but %v:", test.pattern, expected, value)
+		}
+	}
+}
+
+func TestConcurrentSearchers(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(file, []byte("foo\nBAR\nfoo bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{Pattern: "foo"}, file + ":1:foo\n" + file + ":3:foo bar\n"},
+		{Options{Pattern: "BAR", List: true}, file + "\n"},
+		{Options{Pattern: "o+", Only: true}, "oo\noo\n"},
+		{Options{Pattern: "bar", IgnoreCase: true, Count: true}, file + ":2\n"},
+		{Options{Pattern: "bar", IgnoreCase: true, Invert: true}, file + ":1:foo\n"},
+	}
+
+	var wg sync.WaitGroup
+	results := make([]bytes.Buffer, len(tests))
+	for i := range tests {
+		opts := tests[i].opts
+		opts.Stdout = &results[i]
+		s, err := New(&opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Search(context.Background(), []string{file}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, test := range tests {
+		if got := results[i].String(); got != test.want {
+			t.Errorf("search %d: want %q but %q", i, test.want, got)
+		}
+	}
+}
+
+func TestSink(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.txt")
+	// "\x93\xfa\x96\x7b\x8c\xea" is "日本語" in Shift_JIS
+	if err := os.WriteFile(file, []byte("abc\r\nx \x93\xfa\x96\x7b\x8c\xea \x93\xfa\x96\x7b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []File
+	var matches []Match
+	s, err := New(&Options{
+		Pattern: "日本",
+		Before:  1,
+		Sink: &testSink{
+			file: func(f *File) { files = append(files, *f) },
+			match: func(m *Match) {
+				matches = append(matches, *m)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(context.Background(), []string{file}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Path != file || files[0].Encoding != "sjis" || !files[0].Matched {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("want 2 matches but %d", len(matches))
+	}
+	if m := matches[0]; !m.Context || m.Line != 1 || string(m.Text) != "abc" {
+		t.Errorf("unexpected context: %+v", m)
+	}
+	m := matches[1]
+	if m.Context || m.Line != 2 || m.Offset != 5 || m.Column != 3 || m.Encoding != "sjis" {
+		t.Errorf("unexpected match: %+v", m)
+	}
+	if string(m.Text) != "x 日本語 日本" {
+		t.Errorf("want %q but %q", "x 日本語 日本", m.Text)
+	}
+	if len(m.Submatches) != 2 || m.Submatches[1][0] != 12 || m.Submatches[1][1] != 18 {
+		t.Errorf("unexpected submatches: %v", m.Submatches)
+	}
+}
+
+type testSink struct {
+	file  func(f *File)
+	match func(m *Match)
+}
+
+func (s *testSink) BeginFile(f *File) {}
+
+func (s *testSink) Match(m *Match) { s.match(m) }
+
+func (s *testSink) EndFile(f *File) { s.file(f) }
+
+func TestSearchCanceled(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 10; i++ {
+		file := filepath.Join(dir, string(rune('a'+i))+".txt")
+		if err := os.WriteFile(file, []byte("foo\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	s, err := New(&Options{Pattern: "foo", Recursive: true, Stdout: &buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	matched, err := s.Search(ctx, []string{dir})
+	if err != context.Canceled {
+		t.Fatalf("want %v but %v", context.Canceled, err)
+	}
+	if matched || buf.Len() > 0 {
+		t.Errorf("should not search after canceled: %q", buf.String())
+	}
+}
+
+func TestSearchSortPath(t *testing.T) {
+	dir := t.TempDir()
+	var want string
+	for _, name := range []string{"a/1.txt", "a/2.txt", "b.txt", "c/d/3.txt", "c/e.txt"} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("foo\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		want += file + ":1:foo\n"
+	}
+	for i := 0; i < 5; i++ {
+		var buf bytes.Buffer
+		s, err := New(&Options{Pattern: "foo", Recursive: true, Sort: "path", Stdout: &buf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(context.Background(), []string{dir}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != want {
+			t.Fatalf("want %q but %q", want, buf.String())
+		}
+	}
+}
+
+func TestSearchCount(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt": "foo foo\nbar\nfoo\n",
+		"b.txt": "bar\n",
+		// "\x93\xfa\x96\x7b" is "日本" in Shift_JIS
+		"c.txt": "\x93\xfa\x96\x7b foo \x93\xfa\x96\x7b\nfoo\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		opts  Options
+		want  string
+		total int64
+	}{
+		{Options{Pattern: "foo", Count: true}, "a.txt:2\nb.txt:0\nc.txt:2\n", 4},
+		{Options{Pattern: "foo", CountMatches: true}, "a.txt:3\nb.txt:0\nc.txt:2\n", 5},
+		{Options{Pattern: "日本", CountMatches: true}, "a.txt:0\nb.txt:0\nc.txt:2\n", 2},
+		{Options{Pattern: "bar", Count: true}, "a.txt:1\nb.txt:1\nc.txt:0\n", 2},
+		{Options{Pattern: "foo", CountTotal: true}, "4\n", 4},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		test.opts.Sort = "path"
+		test.opts.Stdout = &buf
+		s, err := New(&test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the totals are counted anew for each search
+		for i := 0; i < 2; i++ {
+			buf.Reset()
+			if _, err := s.Search(context.Background(), []string{dir}); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.ReplaceAll(buf.String(), dir+string(filepath.Separator), "")
+			if got != test.want {
+				t.Errorf("%+v: search %d: want %q but %q", test.opts, i, test.want, got)
+			}
+			if st := s.Stats(); st.Matches != test.total || st.Files != 3 {
+				t.Errorf("%+v: search %d: want total %d in 3 files but %d in %d", test.opts, i, test.total, st.Matches, st.Files)
+			}
+		}
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("foo 1\nbar\nfoo 2\nbaz\nfoo 3\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		opts Options
+		want string
+	}{
+		{Options{MaxCount: 1}, "a.txt:1:foo 1\nb.txt:1:foo 1\nc.txt:1:foo 1\n"},
+		{Options{MaxCount: 2, After: 1}, "a.txt:1:foo 1\na.txt:2-bar\na.txt:3:foo 2\na.txt:4-baz\n---\n" +
+			"b.txt:1:foo 1\nb.txt:2-bar\nb.txt:3:foo 2\nb.txt:4-baz\n---\n" +
+			"c.txt:1:foo 1\nc.txt:2-bar\nc.txt:3:foo 2\nc.txt:4-baz\n"},
+		{Options{Limit: 4}, "a.txt:1:foo 1\na.txt:3:foo 2\na.txt:5:foo 3\nb.txt:1:foo 1\n"},
+		{Options{Limit: 2, After: 1}, "a.txt:1:foo 1\na.txt:2-bar\na.txt:3:foo 2\na.txt:4-baz\n"},
+		{Options{Limit: 2, List: true}, "a.txt\nb.txt\n"},
+		{Options{Limit: 2, MaxCount: 1, Count: true}, "a.txt:1\nb.txt:1\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		test.opts.Pattern = "foo"
+		test.opts.Sort = "path"
+		test.opts.Stdout = &buf
+		s, err := New(&test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a Searcher may search again, with the limit counted anew
+		for i := 0; i < 2; i++ {
+			buf.Reset()
+			matched, err := s.Search(context.Background(), []string{dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !matched {
+				t.Errorf("%+v: search %d: want matched", test.opts, i)
+			}
+			got := strings.ReplaceAll(buf.String(), dir+string(filepath.Separator), "")
+			if got != test.want {
+				t.Errorf("%+v: search %d: want %q but %q", test.opts, i, test.want, got)
+			}
+		}
+	}
+}
+
+func TestSearchReaderRawOutput(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := New(&Options{Pattern: "foo", RawOutput: true, Stdout: &buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "foo\n" in UTF-16LE with a BOM
+	in := "\xff\xfef\x00o\x00o\x00\n\x00"
+	if _, err := s.SearchReader(context.Background(), "stdin", strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "f\x00o\x00o\x00\n\x00"; buf.String() != want {
+		t.Errorf("want %q but %q", want, buf.String())
+	}
+}
+
+func TestSearchChunks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.txt")
+	var b []byte
+	for i := 0; i < 50; i++ {
+		// "\xc6\xfc\xcb\xdc" is "日本" in EUC-JP
+		if i%7 == 0 {
+			b = append(b, fmt.Sprintf("%d \xc6\xfc\xcb\xdc\r\n", i)...)
+		} else {
+			b = append(b, fmt.Sprintf("%d %s\r\n", i, strings.Repeat("x", i))...)
+		}
+	}
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func() []Match {
+		var matches []Match
+		s, err := New(&Options{
+			Pattern: "日本",
+			Before:  2,
+			After:   1,
+			Sink:    SinkFunc(func(m *Match) { matches = append(matches, *m) }),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(context.Background(), []string{file}); err != nil {
+			t.Fatal(err)
+		}
+		return matches
+	}
+	want := search()
+	if len(want) != 29 {
+		t.Fatalf("want 29 lines but %d", len(want))
+	}
+	defer func(n int) { decodeChunkSize = n }(decodeChunkSize)
+	for _, decodeChunkSize = range []int{8, 16, 100} {
+		got := search()
+		if len(got) != len(want) {
+			t.Fatalf("chunk size %d: want %d lines but %d", decodeChunkSize, len(want), len(got))
+		}
+		for i := range want {
+			w, g := want[i], got[i]
+			if g.Line != w.Line || g.Offset != w.Offset || g.Context != w.Context || string(g.Text) != string(w.Text) {
+				t.Errorf("chunk size %d: want %+v but %+v", decodeChunkSize, w, g)
+			}
+		}
+	}
+}
+
+func TestSearchDecodedStream(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.txt")
+	var b []byte
+	for i := 0; i < 20; i++ {
+		// "\x93\xfa\x96\x7b" is "日本" in Shift_JIS
+		b = append(b, fmt.Sprintf("%d \x93\xfa\x96\x7b\n", i)...)
+	}
+	// not valid in Shift_JIS, but the results before it are out already
+	b = append(b, "\x85\x40 \x93\xfa\x96\x7b\n"...)
+
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(n int) { decodeChunkSize = n }(decodeChunkSize)
+	decodeChunkSize = 16
+	var s *Searcher
+	var lines []int
+	var found int64 // matches found when the first is passed to the sink
+	s, err := New(&Options{
+		Pattern:   "日本",
+		Encodings: []string{"sjis"},
+		Sink: SinkFunc(func(m *Match) {
+			if len(lines) == 0 {
+				found = s.Count()
+			}
+			lines = append(lines, m.Line)
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(context.Background(), []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 21 {
+		t.Fatalf("want 21 lines but %d", len(lines))
+	}
+	if found >= 21 {
+		t.Errorf("want the results passed to the sink as they are found, but %d were found first", found)
+	}
+}
+
+// eventSink records the calls it receives as "begin path", "match path" and
+// "end path". It yields on each match to let the other workers run.
+type eventSink struct {
+	events []string
+}
+
+func (s *eventSink) BeginFile(f *File) { s.events = append(s.events, "begin "+f.Path) }
+
+func (s *eventSink) Match(m *Match) {
+	s.events = append(s.events, "match "+m.Path)
+	runtime.Gosched()
+}
+
+func (s *eventSink) EndFile(f *File) { s.events = append(s.events, "end "+f.Path) }
+
+func TestSearchStreamBlocks(t *testing.T) {
+	dir := t.TempDir()
+	var b []byte
+	for i := 0; i < 200; i++ {
+		// "\x93\xfa\x96\x7b" is "日本" in Shift_JIS
+		b = append(b, fmt.Sprintf("%d \x93\xfa\x96\x7b\n", i)...)
+	}
+	for i := 0; i < 20; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%02d.txt", i)), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(n int) { decodeChunkSize = n }(decodeChunkSize)
+	decodeChunkSize = 16
+	for _, sort := range []string{"", "path"} {
+		var sink eventSink
+		s, err := New(&Options{
+			Pattern:   "日本",
+			Encodings: []string{"sjis"},
+			Recursive: true,
+			Workers:   4,
+			Sort:      sort,
+			Sink:      &sink,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(context.Background(), []string{dir}); err != nil {
+			t.Fatal(err)
+		}
+		// the events of each file are in one block
+		var path string
+		files := 0
+		for i, ev := range sink.events {
+			f := strings.SplitN(ev, " ", 2)
+			kind, p := f[0], f[1]
+			switch {
+			case kind == "begin" && path == "":
+				path = p
+				files++
+			case kind == "match" && p == path:
+			case kind == "end" && p == path:
+				path = ""
+			default:
+				t.Fatalf("sort %q: event %d: unexpected %q in %q", sort, i, ev, path)
+			}
+		}
+		if files != 20 || len(sink.events) != 20*202 {
+			t.Errorf("sort %q: want 20 files and %d events but %d and %d", sort, 20*202, files, len(sink.events))
+		}
+	}
+}
+
+func TestSearchRawOutput(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.txt")
+	var lines []string
+	for i := 0; i < 20; i++ {
+		// "\xa4\xb3\xa4\xec" is "これ" and "\xc6\xfc\xcb\xdc" is "日本" in EUC-JP
+		if i%5 == 0 {
+			lines = append(lines, fmt.Sprintf("\xa4\xb3\xa4\xec %d \xc6\xfc\xcb\xdc", i))
+		} else {
+			lines = append(lines, fmt.Sprintf("%d %s", i, strings.Repeat("x", i)))
+		}
+	}
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\r\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(n int) { decodeChunkSize = n }(decodeChunkSize)
+	for _, decodeChunkSize = range []int{16, 256 * 1024} {
+		var matches []Match
+		s, err := New(&Options{
+			Pattern:   "日本",
+			Encodings: []string{"euc-jp"},
+			Before:    1,
+			RawOutput: true,
+			Sink:      SinkFunc(func(m *Match) { matches = append(matches, *m) }),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(context.Background(), []string{file}); err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 7 {
+			t.Fatalf("chunk size %d: want 7 lines but %d", decodeChunkSize, len(matches))
+		}
+		for _, m := range matches {
+			if want := lines[m.Line-1]; string(m.Text) != want {
+				t.Errorf("chunk size %d: line %d: want %q but %q", decodeChunkSize, m.Line, want, m.Text)
+			}
+			if m.Context {
+				continue
+			}
+			if len(m.Submatches) != 1 || string(m.Text[m.Submatches[0][0]:m.Submatches[0][1]]) != "\xc6\xfc\xcb\xdc" {
+				t.Errorf("chunk size %d: line %d: wrong submatches %v", decodeChunkSize, m.Line, m.Submatches)
+			}
+		}
+	}
+}
+
+func TestSearchMixedEncoding(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "mixed.log")
+	b := "utf-8 日本語\n" +
+		"\x93\xfa\x96\x7b\x8c\xea sjis\n" + // "日本語" in Shift_JIS
+		"ascii\n" +
+		"\xc6\xfc\xcb\xdc\xb8\xec euc-jp\n" + // "日本語" in EUC-JP
+		"utf-8 日本語 again\n"
+	if err := os.WriteFile(file, []byte(b), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	s, err := New(&Options{
+		Pattern:       "日本語",
+		MixedEncoding: true,
+		Sink: SinkFunc(func(m *Match) {
+			got = append(got, fmt.Sprintf("%d:%s:%s", m.Line, m.Encoding, m.Text))
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(context.Background(), []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1:utf-8:utf-8 日本語",
+		"2:sjis:日本語 sjis",
+		"4:euc-jp:日本語 euc-jp",
+		"5:utf-8:utf-8 日本語 again",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want %q but %q", want, got)
+	}
+}
+
+func TestNulEncoding(t *testing.T) {
+	utf16 := func(s string, bigEndian bool) []byte {
+		var b []byte
+		for _, r := range s {
+			if bigEndian {
+				b = append(b, byte(r>>8), byte(r))
+			} else {
+				b = append(b, byte(r), byte(r>>8))
+			}
+		}
+		return b
+	}
+	tests := []struct {
+		b    []byte
+		want string
+	}{
+		{utf16("Name,Value\r\n日本語,1\r\n", false), "utf-16le"},
+		{utf16("Name,Value\r\n日本語,1\r\n", true), "utf-16be"},
+		{[]byte("a\x00\x00\x00b\x00\x00\x00\xe5\x65\x00\x00\n\x00\x00\x00"), "utf-32le"},
+		{[]byte("\x00\x00\x00a\x00\x00\x00b\x00\x00\x65\xe5\x00\x00\x00\n"), "utf-32be"},
+		{[]byte("plain text\n"), ""},
+		{[]byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03\x00\x3e\x00"), ""},
+	}
+	for _, test := range tests {
+		if got := nulEncoding(test.b); got != test.want {
+			t.Errorf("%q: want %q but %q", test.b, test.want, got)
+		}
+	}
+
+	// files in UTF-16 without a BOM are text for IgnoreBinary
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.csv")
+	if err := os.WriteFile(file, utf16("Name,Value\r\n日本語,1\r\n", false), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	s, err := New(&Options{
+		Pattern:      "日本",
+		IgnoreBinary: true,
+		Sink:         SinkFunc(func(m *Match) { got = append(got, m.Encoding+":"+string(m.Text)) }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(context.Background(), []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "utf-16le:日本語,1" {
+		t.Errorf("want utf-16le:日本語,1 but %q", got)
+	}
+}
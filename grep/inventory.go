@@ -0,0 +1,231 @@
+package grep
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// Report describes the encoding of a file, for Options.Inventory.
+type Report struct {
+	Encoding   string  // encoding detected for the file, or "" if it is binary
+	Confidence float64 // confidence of the detection, from 0 to 1, or 0 if it is binary
+	BOM        bool    // the file starts with a byte order mark
+	LineEnding string  // "CRLF", "LF", "CR", "mixed", or "" without line breaks
+	Binary     bool    // the file looks binary
+	Lossy      bool    // the file does not decode cleanly in Encoding
+}
+
+// inventory detects the encoding of fb and how it decodes, without
+// searching it.
+func (s *Searcher) inventory(path string, fb []byte, arg *grepArg) *Report {
+	fb, encs, binary := s.prepare(path, fb, arg)
+	r := &Report{BOM: len(arg.bom) > 0, Binary: binary}
+	if binary {
+		// no encoding is detected
+		arg.enc = ""
+		return r
+	}
+	r.Confidence = 1
+	enc := encs[0]
+	if len(encs) > 1 {
+		d := Detect(fb, encs)
+		s.debug("detect("+d.Encoding+"):", path+":", d.String())
+		if valid := d.Encodings(); len(valid) > 0 {
+			enc = valid[0]
+			r.Confidence = d.Confidence
+		} else {
+			// no encoding decodes it, like a file of mixed encodings
+			enc = "unknown"
+			r.Confidence = 0
+			r.Lossy = true
+		}
+	}
+	if enc == "" {
+		enc = "utf-8"
+	}
+	r.Encoding = enc
+	arg.enc = enc
+
+	var c eolCounter
+	if enc == "unknown" {
+		c.count(fb)
+	} else if canonicalEncoding(enc) == "utf-8" {
+		c.count(fb)
+		r.Lossy = !utf8.Valid(fb)
+	} else if e, _ := lookupEncoding(enc); e != nil {
+		lossy, err := decodeEach(fb, e, c.count)
+		r.Lossy = lossy || err != nil
+	}
+	r.LineEnding = c.style()
+	return r
+}
+
+// decodeEach decodes fb with the encoding e and passes the text to fn in
+// chunks. It reports whether any of the text failed to decode.
+func decodeEach(fb []byte, e encoding.Encoding, fn func([]byte)) (lossy bool, err error) {
+	r := transform.NewReader(bytes.NewReader(fb), e.NewDecoder())
+	buf := make([]byte, 64*1024)
+	var tail []byte // end of the last chunk, for a '\uFFFD' split between chunks
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunk := buf[:n]
+			fn(chunk)
+			head := chunk
+			if len(head) > 2 {
+				head = head[:2]
+			}
+			if bytes.Contains(chunk, replbytes) || bytes.Contains(append(tail, head...), replbytes) {
+				lossy = true
+			}
+			if len(chunk) >= 2 {
+				tail = append(tail[:0], chunk[len(chunk)-2:]...)
+			} else {
+				tail = append(tail, chunk...)
+			}
+		}
+		if err == io.EOF {
+			return lossy, nil
+		}
+		if err != nil {
+			return lossy, err
+		}
+	}
+}
+
+// eolCounter counts the line breaks of a text given in chunks.
+type eolCounter struct {
+	crlf, lf, cr int
+	pendingCR    bool
+}
+
+func (c *eolCounter) count(b []byte) {
+	for _, ch := range b {
+		switch {
+		case ch == '\n' && c.pendingCR:
+			c.crlf++
+		case ch == '\n':
+			c.lf++
+		case c.pendingCR:
+			c.cr++
+		}
+		c.pendingCR = ch == '\r'
+	}
+}
+
+// style returns the kind of line breaks counted.
+func (c *eolCounter) style() string {
+	if c.pendingCR {
+		c.cr++
+		c.pendingCR = false
+	}
+	style, kinds := "", 0
+	for _, k := range []struct {
+		n    int
+		name string
+	}{{c.crlf, "CRLF"}, {c.lf, "LF"}, {c.cr, "CR"}} {
+		if k.n > 0 {
+			style = k.name
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return "mixed"
+	}
+	return style
+}
+
+// inventoryRow is a row of the summary table of Options.Inventory.
+type inventoryRow struct {
+	files, bom, crlf, lf, cr, mixed, lossy int
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// writeReport writes a line of the report of f, with a header before the
+// first one.
+func (p *textPrinter) writeReport(f *File) {
+	r := f.Report
+	if p.inventory == nil {
+		p.inventory = map[string]*inventoryRow{}
+		fmt.Fprintf(&p.buf, "%-12s %-5s %-3s %-5s %-6s %-5s %s", "ENCODING", "CONF", "BOM", "EOL", "BINARY", "LOSSY", "PATH")
+		p.writeEOL()
+	}
+	enc, eol := r.Encoding, r.LineEnding
+	if r.Binary {
+		enc = "binary"
+	}
+	if eol == "" {
+		eol = "-"
+	}
+	fmt.Fprintf(&p.buf, "%-12s %.2f  %-3s %-5s %-6s %-5s ", enc, r.Confidence, yesNo(r.BOM), eol, yesNo(r.Binary), yesNo(r.Lossy))
+	p.buf.WriteString(p.colors.path + f.Path + p.colors.reset)
+	p.writeEOL()
+
+	row := p.inventory[enc]
+	if row == nil {
+		row = &inventoryRow{}
+		p.inventory[enc] = row
+	}
+	row.files++
+	if r.BOM {
+		row.bom++
+	}
+	switch r.LineEnding {
+	case "CRLF":
+		row.crlf++
+	case "LF":
+		row.lf++
+	case "CR":
+		row.cr++
+	case "mixed":
+		row.mixed++
+	}
+	if r.Lossy {
+		row.lossy++
+	}
+}
+
+// writeInventory writes the summary table of Options.Inventory by encoding,
+// the most common encoding first.
+func (p *textPrinter) writeInventory() {
+	var encs []string
+	var total inventoryRow
+	for enc, row := range p.inventory {
+		encs = append(encs, enc)
+		total.files += row.files
+		total.bom += row.bom
+		total.crlf += row.crlf
+		total.lf += row.lf
+		total.cr += row.cr
+		total.mixed += row.mixed
+		total.lossy += row.lossy
+	}
+	sort.Slice(encs, func(i, j int) bool {
+		ri, rj := p.inventory[encs[i]], p.inventory[encs[j]]
+		if ri.files != rj.files {
+			return ri.files > rj.files
+		}
+		return encs[i] < encs[j]
+	})
+	write := func(name string, r *inventoryRow) {
+		fmt.Fprintf(p.w, "%-12s %6d %6d %6d %6d %6d %6d %6d\n", name, r.files, r.bom, r.crlf, r.lf, r.cr, r.mixed, r.lossy)
+	}
+	fmt.Fprintln(p.w)
+	fmt.Fprintf(p.w, "%-12s %6s %6s %6s %6s %6s %6s %6s\n", "ENCODING", "FILES", "BOM", "CRLF", "LF", "CR", "MIXED", "LOSSY")
+	for _, enc := range encs {
+		write(enc, p.inventory[enc])
+	}
+	write("total", &total)
+}
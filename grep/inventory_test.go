@@ -0,0 +1,104 @@
+package grep
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEOLCounter(t *testing.T) {
+	tests := []struct {
+		chunks []string
+		want   string
+	}{
+		{[]string{"a\nb\n"}, "LF"},
+		{[]string{"a\r\nb\r\n"}, "CRLF"},
+		{[]string{"a\r", "\nb\r\n"}, "CRLF"},
+		{[]string{"a\rb\r"}, "CR"},
+		{[]string{"a\r\nb\n"}, "mixed"},
+		{[]string{"a\r\r\n"}, "mixed"},
+		{[]string{"abc"}, ""},
+	}
+	for _, test := range tests {
+		var c eolCounter
+		for _, chunk := range test.chunks {
+			c.count([]byte(chunk))
+		}
+		if got := c.style(); got != test.want {
+			t.Errorf("%q: want %q but %q", test.chunks, test.want, got)
+		}
+	}
+}
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt":   "\x82\xb1\x82\xea\x82\xcd\r\n\x93\xfa\x96\x7b\x8c\xea\r\n", // "これは", "日本語" in Shift_JIS
+		"b.txt":   "\xef\xbb\xbfutf-8 日本語\n",
+		"c.txt":   "utf-8 日本語\n\x82\xb1\x82\xea\n", // UTF-8 and Shift_JIS
+		"d.bin":   "\x7fELF\x02\x01\x01\x00\x00\x00",
+		"e.txt":   "a\rb\n",
+		"f.utf16": "a\x00b\x00\r\x00\n\x00",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reports := map[string]Report{}
+	s, err := New(&Options{
+		Inventory: true,
+		Recursive: true,
+		Sink: &testSink{
+			file:  func(f *File) { reports[filepath.Base(f.Path)] = *f.Report },
+			match: func(m *Match) { t.Errorf("unexpected match: %+v", m) },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(context.Background(), []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Report{
+		"a.txt":   {Encoding: "sjis", Confidence: 1, LineEnding: "CRLF"},
+		"b.txt":   {Encoding: "utf-8", Confidence: 1, BOM: true, LineEnding: "LF"},
+		"c.txt":   {Encoding: "unknown", LineEnding: "LF", Lossy: true},
+		"d.bin":   {Binary: true},
+		"e.txt":   {Encoding: "utf-8", Confidence: 1, LineEnding: "mixed"},
+		"f.utf16": {Encoding: "utf-16le", Confidence: 1, LineEnding: "CRLF"},
+	}
+	for name, w := range want {
+		if got, ok := reports[name]; !ok || got != w {
+			t.Errorf("%s: want %+v but %+v", name, w, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	p, err := NewPrinter("plain", &buf, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "d.bin"} {
+		r := reports[name]
+		f := &File{Path: name, Report: &r}
+		p.BeginFile(f)
+		p.EndFile(f)
+	}
+	p.Finish(&Stats{})
+	got := buf.String()
+	for _, line := range []string{
+		"sjis         1.00  no  CRLF  no     no    a.txt\n",
+		"binary       0.00  no  -     yes    no    d.bin\n",
+		"sjis              1      0      1      0      0      0      0\n",
+		"total             2      0      1      0      0      0      0\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("want %q in:\n%s", line, got)
+		}
+	}
+}
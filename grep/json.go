@@ -0,0 +1,155 @@
+package grep
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"unicode/utf8"
+)
+
+// jsonPrinter writes the results as JSON Lines. Each line is an event object
+// with a type of "begin", "match", "context", "end" or "summary".
+type jsonPrinter struct {
+	opts *Options
+	w    io.Writer
+	buf  bytes.Buffer
+	enc  *json.Encoder
+}
+
+func newJSONPrinter(w io.Writer, opts *Options) Printer {
+	p := &jsonPrinter{opts: opts, w: w}
+	p.enc = json.NewEncoder(&p.buf)
+	p.enc.SetEscapeHTML(false)
+	return p
+}
+
+// jsonData holds a string as text if it is valid UTF-8, or as the base64
+// encoded bytes otherwise, so that no byte is lost.
+type jsonData struct {
+	Text  *string `json:"text,omitempty"`
+	Bytes []byte  `json:"bytes,omitempty"`
+}
+
+func newJSONData(b []byte) jsonData {
+	if utf8.Valid(b) {
+		s := string(b)
+		return jsonData{Text: &s}
+	}
+	return jsonData{Bytes: b}
+}
+
+type jsonEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type jsonBegin struct {
+	Path jsonData `json:"path"`
+}
+
+type jsonEnd struct {
+	Path     jsonData    `json:"path"`
+	Encoding string      `json:"encoding"`
+	BOM      bool        `json:"bom"`
+	Matched  bool        `json:"matched"`
+	Count    int64       `json:"count"`
+	Report   *jsonReport `json:"report,omitempty"`
+}
+
+type jsonReport struct {
+	Encoding   string  `json:"encoding"`
+	Confidence float64 `json:"confidence"`
+	BOM        bool    `json:"bom"`
+	LineEnding string  `json:"line_ending"`
+	Binary     bool    `json:"binary"`
+	Lossy      bool    `json:"lossy"`
+}
+
+type jsonSubmatch struct {
+	Match jsonData `json:"match"`
+	Start int      `json:"start"`
+	End   int      `json:"end"`
+}
+
+type jsonMatch struct {
+	Path       jsonData       `json:"path"`
+	Text       jsonData       `json:"text"`
+	Line       int            `json:"line_number"`
+	Offset     int64          `json:"offset"`
+	Column     int            `json:"column"`
+	Submatches []jsonSubmatch `json:"submatches"`
+	Encoding   string         `json:"encoding"`
+	BOM        bool           `json:"bom"`
+}
+
+type jsonSummary struct {
+	Matches      int64 `json:"matches"`
+	Files        int64 `json:"files"`
+	MatchedFiles int64 `json:"matched_files"`
+}
+
+func (p *jsonPrinter) BeginFile(f *File) {
+	p.write("begin", &jsonBegin{Path: newJSONData([]byte(f.Path))})
+}
+
+func (p *jsonPrinter) Match(m *Match) {
+	typ := "match"
+	if m.Context {
+		typ = "context"
+	}
+	submatches := make([]jsonSubmatch, 0, len(m.Submatches))
+	for _, mm := range m.Submatches {
+		submatches = append(submatches, jsonSubmatch{
+			Match: newJSONData(m.Text[mm[0]:mm[1]]),
+			Start: mm[0],
+			End:   mm[1],
+		})
+	}
+	p.write(typ, &jsonMatch{
+		Path:       newJSONData([]byte(m.Path)),
+		Text:       newJSONData(m.Text),
+		Line:       m.Line,
+		Offset:     m.Offset,
+		Column:     m.Column,
+		Submatches: submatches,
+		Encoding:   m.Encoding,
+		BOM:        len(m.BOM) > 0,
+	})
+}
+
+func (p *jsonPrinter) EndFile(f *File) {
+	end := &jsonEnd{
+		Path:     newJSONData([]byte(f.Path)),
+		Encoding: f.Encoding,
+		BOM:      len(f.BOM) > 0,
+		Matched:  f.Matched,
+		Count:    f.Count,
+	}
+	if r := f.Report; r != nil {
+		end.Report = &jsonReport{
+			Encoding:   r.Encoding,
+			Confidence: r.Confidence,
+			BOM:        r.BOM,
+			LineEnding: r.LineEnding,
+			Binary:     r.Binary,
+			Lossy:      r.Lossy,
+		}
+	}
+	p.write("end", end)
+	p.w.Write(p.buf.Bytes())
+	p.buf.Reset()
+}
+
+func (p *jsonPrinter) Finish(st *Stats) {
+	p.write("summary", &jsonSummary{
+		Matches:      st.Matches,
+		Files:        st.Files,
+		MatchedFiles: st.MatchedFiles,
+	})
+	p.w.Write(p.buf.Bytes())
+	p.buf.Reset()
+}
+
+func (p *jsonPrinter) write(typ string, data interface{}) {
+	p.enc.Encode(&jsonEvent{Type: typ, Data: data})
+}
@@ -0,0 +1,285 @@
+package grep
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// Matcher finds the matches of a pattern in a line of UTF-8 text.
+type Matcher interface {
+	// Find returns the span of the leftmost match in line, or nil if there
+	// is no match.
+	Find(line []byte) []int
+
+	// FindAll returns the spans of all the successive non-overlapping
+	// matches in line.
+	FindAll(line []byte) [][]int
+
+	// Literal reports whether the pattern matches a fixed string. A literal
+	// matcher never matches across lines, so it may be applied to the raw
+	// bytes of a UTF-8 file.
+	Literal() bool
+}
+
+type literalMatcher struct {
+	needle []byte
+}
+
+// NewLiteralMatcher returns a Matcher that matches the string s.
+func NewLiteralMatcher(s string) Matcher {
+	return &literalMatcher{needle: []byte(s)}
+}
+
+func (m *literalMatcher) Find(line []byte) []int {
+	return matchFixed(line, m.needle)
+}
+
+func (m *literalMatcher) FindAll(line []byte) [][]int {
+	return findIndexes(line, len(m.needle), func(b []byte) int {
+		return bytes.Index(b, m.needle)
+	})
+}
+
+func (m *literalMatcher) Literal() bool {
+	return true
+}
+
+type foldASCIIMatcher struct {
+	folded []byte
+}
+
+// NewFoldASCIIMatcher returns a Matcher that matches the string s ignoring
+// the case of ASCII letters.
+func NewFoldASCIIMatcher(s string) Matcher {
+	m := &foldASCIIMatcher{folded: make([]byte, len(s))}
+	lowerASCIIBytes(m.folded, []byte(s))
+	return m
+}
+
+func (m *foldASCIIMatcher) Find(line []byte) []int {
+	idx := indexFoldASCII(line, m.folded)
+	if idx < 0 {
+		return nil
+	}
+	return []int{idx, idx + len(m.folded)}
+}
+
+func (m *foldASCIIMatcher) FindAll(line []byte) [][]int {
+	return findIndexes(line, len(m.folded), func(b []byte) int {
+		return indexFoldASCII(b, m.folded)
+	})
+}
+
+func (m *foldASCIIMatcher) Literal() bool {
+	return true
+}
+
+type regexpMatcher struct {
+	re *regexp.Regexp
+}
+
+// NewRegexpMatcher returns a Matcher that matches the regular expression re.
+func NewRegexpMatcher(re *regexp.Regexp) Matcher {
+	return &regexpMatcher{re: re}
+}
+
+func (m *regexpMatcher) Find(line []byte) []int {
+	return m.re.FindIndex(line)
+}
+
+func (m *regexpMatcher) FindAll(line []byte) [][]int {
+	return m.re.FindAllIndex(line, -1)
+}
+
+func (m *regexpMatcher) Literal() bool {
+	return false
+}
+
+// compileMatcher returns the Matcher for the pattern in opts. ascii reports
+// that the matcher only matches ASCII text.
+func compileMatcher(opts *Options, debug func(...interface{})) (m Matcher, ascii bool, err error) {
+	if opts.Migemo {
+		pattern, err := migemoPattern(opts)
+		if err != nil {
+			return nil, false, err
+		}
+		debug("migemo pattern:", pattern)
+		o := *opts
+		o.Pattern, o.Fixed, o.Perl, o.Migemo = pattern, false, false, false
+		opts = &o
+	}
+	instr := opts.Pattern
+	folders, err := newFolders(opts)
+	if err != nil {
+		return nil, false, err
+	}
+	if folders != nil {
+		if instr, err = foldPattern(opts, folders); err != nil {
+			return nil, false, err
+		}
+		debug("folded pattern:", instr)
+		defer func() {
+			if m != nil {
+				// the folded pattern matches the text folded from it
+				m, ascii = &foldMatcher{m: m, folders: folders}, false
+			}
+		}()
+	}
+	if opts.Fixed {
+		ascii = isASCII(instr)
+		if !opts.IgnoreCase {
+			return NewLiteralMatcher(instr), ascii, nil
+		}
+		if ascii {
+			return NewFoldASCIIMatcher(instr), ascii, nil
+		}
+		re, err := regexp.Compile("(?i:" + regexp.QuoteMeta(instr) + ")")
+		if err != nil {
+			return nil, false, err
+		}
+		return NewRegexpMatcher(re), ascii, nil
+	}
+	if opts.Perl {
+		re, err := syntax.Parse(instr, syntax.Perl)
+		if err != nil {
+			return nil, false, err
+		}
+		rec, err := syntax.Compile(re)
+		if err != nil {
+			return nil, false, err
+		}
+		instr = rec.String()
+	}
+	if opts.IgnoreCase {
+		instr = "(?i:" + instr + ")"
+	}
+	if isLiteralRegexp(instr) {
+		debug("pattern treated as literal:", instr)
+		return NewLiteralMatcher(instr), isASCII(instr), nil
+	}
+	re, err := regexp.Compile(instr)
+	if err != nil {
+		return nil, false, err
+	}
+	return NewRegexpMatcher(re), false, nil
+}
+
+func matchFixed(data, needle []byte) []int {
+	idx := bytes.Index(data, needle)
+	if idx < 0 {
+		return nil
+	}
+	return []int{idx, idx + len(needle)}
+}
+
+func findIndexes(line []byte, n int, index func([]byte) int) [][]int {
+	var indexes [][]int
+	offset := 0
+	for offset <= len(line)-n {
+		idx := index(line[offset:])
+		if idx < 0 {
+			break
+		}
+		idx += offset
+		indexes = append(indexes, []int{idx, idx + n})
+		offset = idx + n
+		if n == 0 {
+			offset++
+		}
+	}
+	return indexes
+}
+
+func lowerASCIIByte(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + ('a' - 'A')
+	}
+	return b
+}
+
+func lowerASCIIBytes(dst, src []byte) {
+	for i, b := range src {
+		dst[i] = lowerASCIIByte(b)
+	}
+}
+
+func indexFoldASCII(data, needle []byte) int {
+	nl := len(needle)
+	dl := len(data)
+	if nl == 0 {
+		return 0
+	}
+	if nl > dl {
+		return -1
+	}
+	last := dl - nl
+	first := needle[0]
+	for i := 0; i <= last; i++ {
+		if lowerASCIIByte(data[i]) != first {
+			continue
+		}
+		j := 1
+		for ; j < nl; j++ {
+			if lowerASCIIByte(data[i+j]) != needle[j] {
+				break
+			}
+		}
+		if j == nl {
+			return i
+		}
+	}
+	return -1
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// isLiteralRegexp checks regexp is a simple literal or not.
+func isLiteralRegexp(expr string) bool {
+	return regexp.QuoteMeta(expr) == expr
+}
+
+// requiredLiteral returns a string that every match of the pattern of opts
+// contains, or "" if there is none.
+func requiredLiteral(opts *Options) string {
+	if opts.Fixed {
+		return opts.Pattern
+	}
+	re, err := syntax.Parse(opts.Pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	return requiredLiteralOf(re.Simplify())
+}
+
+func requiredLiteralOf(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return string(re.Rune)
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteralOf(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiteralOf(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		lit := ""
+		for _, sub := range re.Sub {
+			if l := requiredLiteralOf(sub); len(l) > len(lit) {
+				lit = l
+			}
+		}
+		return lit
+	}
+	return ""
+}
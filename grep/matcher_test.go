@@ -0,0 +1,88 @@
+package grep
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchers(t *testing.T) {
+	tests := []struct {
+		opts Options
+		line string
+		want [][]int
+	}{
+		{Options{Pattern: "aa", Fixed: true}, "aaaaa", [][]int{{0, 2}, {2, 4}}},
+		{Options{Pattern: "Go", Fixed: true, IgnoreCase: true}, "go GO gO", [][]int{{0, 2}, {3, 5}, {6, 8}}},
+		{Options{Pattern: "ＡＢ", Fixed: true, IgnoreCase: true}, "ａｂ", [][]int{{0, 6}}},
+		{Options{Pattern: "a+b"}, "ab aab b", [][]int{{0, 2}, {3, 6}}},
+		{Options{Pattern: "foo.bar", Fixed: true}, "fooxbar foo.bar", [][]int{{8, 15}}},
+	}
+	for _, test := range tests {
+		m, _, err := compileMatcher(&test.opts, func(...interface{}) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := m.FindAll([]byte(test.line))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q in %q: want %v but %v", test.opts.Pattern, test.line, test.want, got)
+		}
+		if first := m.Find([]byte(test.line)); !reflect.DeepEqual(first, test.want[0]) {
+			t.Errorf("%q in %q: want %v but %v", test.opts.Pattern, test.line, test.want[0], first)
+		}
+	}
+}
+
+// wordsMatcher matches any of the words.
+type wordsMatcher [][]byte
+
+func (m wordsMatcher) Find(line []byte) []int {
+	if all := m.FindAll(line); len(all) > 0 {
+		return all[0]
+	}
+	return nil
+}
+
+func (m wordsMatcher) FindAll(line []byte) [][]int {
+	var spans [][]int
+	for i := 0; i < len(line); i++ {
+		for _, w := range m {
+			if bytes.HasPrefix(line[i:], w) {
+				spans = append(spans, []int{i, i + len(w)})
+				i += len(w) - 1
+				break
+			}
+		}
+	}
+	return spans
+}
+
+func (m wordsMatcher) Literal() bool {
+	return false
+}
+
+func TestCustomMatcher(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(file, []byte("apple\nbanana\ncherry\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	s, err := New(&Options{
+		Matcher: wordsMatcher{[]byte("apple"), []byte("cherry")},
+		Only:    true,
+		Stdout:  &buf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(context.Background(), []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "apple\ncherry\n"; buf.String() != want {
+		t.Errorf("want %q but %q", want, buf.String())
+	}
+}
@@ -0,0 +1,273 @@
+package grep
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MigemoDictPaths are the paths where the dictionary of C/Migemo is looked
+// for when Options.MigemoDict is empty.
+var MigemoDictPaths = []string{
+	"/usr/share/cmigemo/utf-8/migemo-dict",
+	"/usr/local/share/cmigemo/utf-8/migemo-dict",
+	"/usr/share/migemo/utf-8/migemo-dict",
+	"/usr/local/share/migemo/utf-8/migemo-dict",
+	"/opt/homebrew/share/migemo/utf-8/migemo-dict",
+	"/usr/share/migemo/migemo-dict",
+}
+
+// romaji maps the syllables of romaji to hiragana.
+var romaji = map[string]string{
+	"a": "あ", "i": "い", "u": "う", "e": "え", "o": "お",
+	"ka": "か", "ki": "き", "ku": "く", "ke": "け", "ko": "こ",
+	"sa": "さ", "si": "し", "su": "す", "se": "せ", "so": "そ",
+	"ta": "た", "ti": "ち", "tu": "つ", "te": "て", "to": "と",
+	"na": "な", "ni": "に", "nu": "ぬ", "ne": "ね", "no": "の",
+	"ha": "は", "hi": "ひ", "hu": "ふ", "he": "へ", "ho": "ほ",
+	"ma": "ま", "mi": "み", "mu": "む", "me": "め", "mo": "も",
+	"ya": "や", "yu": "ゆ", "ye": "いぇ", "yo": "よ",
+	"ra": "ら", "ri": "り", "ru": "る", "re": "れ", "ro": "ろ",
+	"wa": "わ", "wi": "うぃ", "we": "うぇ", "wo": "を",
+	"nn": "ん", "n'": "ん",
+	"ga": "が", "gi": "ぎ", "gu": "ぐ", "ge": "げ", "go": "ご",
+	"za": "ざ", "zi": "じ", "zu": "ず", "ze": "ぜ", "zo": "ぞ",
+	"da": "だ", "di": "ぢ", "du": "づ", "de": "で", "do": "ど",
+	"ba": "ば", "bi": "び", "bu": "ぶ", "be": "べ", "bo": "ぼ",
+	"pa": "ぱ", "pi": "ぴ", "pu": "ぷ", "pe": "ぺ", "po": "ぽ",
+	"va": "ゔぁ", "vi": "ゔぃ", "vu": "ゔ", "ve": "ゔぇ", "vo": "ゔぉ",
+	"fa": "ふぁ", "fi": "ふぃ", "fu": "ふ", "fe": "ふぇ", "fo": "ふぉ",
+	"ja": "じゃ", "ji": "じ", "ju": "じゅ", "je": "じぇ", "jo": "じょ",
+	"shi": "し", "chi": "ち", "tsu": "つ",
+	"sha": "しゃ", "shu": "しゅ", "she": "しぇ", "sho": "しょ",
+	"cha": "ちゃ", "chu": "ちゅ", "che": "ちぇ", "cho": "ちょ",
+	"tsa": "つぁ", "tsi": "つぃ", "tse": "つぇ", "tso": "つぉ",
+	"thi": "てぃ", "dhi": "でぃ", "twu": "とぅ", "dwu": "どぅ",
+	"kya": "きゃ", "kyu": "きゅ", "kyo": "きょ",
+	"sya": "しゃ", "syu": "しゅ", "syo": "しょ",
+	"tya": "ちゃ", "tyu": "ちゅ", "tyo": "ちょ",
+	"nya": "にゃ", "nyu": "にゅ", "nyo": "にょ",
+	"hya": "ひゃ", "hyu": "ひゅ", "hyo": "ひょ",
+	"mya": "みゃ", "myu": "みゅ", "myo": "みょ",
+	"rya": "りゃ", "ryu": "りゅ", "ryo": "りょ",
+	"gya": "ぎゃ", "gyu": "ぎゅ", "gyo": "ぎょ",
+	"zya": "じゃ", "zyu": "じゅ", "zyo": "じょ",
+	"jya": "じゃ", "jyu": "じゅ", "jyo": "じょ",
+	"dya": "ぢゃ", "dyu": "ぢゅ", "dyo": "ぢょ",
+	"bya": "びゃ", "byu": "びゅ", "byo": "びょ",
+	"pya": "ぴゃ", "pyu": "ぴゅ", "pyo": "ぴょ",
+	"xa": "ぁ", "xi": "ぃ", "xu": "ぅ", "xe": "ぇ", "xo": "ぉ",
+	"la": "ぁ", "li": "ぃ", "lu": "ぅ", "le": "ぇ", "lo": "ぉ",
+	"xya": "ゃ", "xyu": "ゅ", "xyo": "ょ", "lya": "ゃ", "lyu": "ゅ", "lyo": "ょ",
+	"xtu": "っ", "ltu": "っ", "xtsu": "っ", "xwa": "ゎ", "lwa": "ゎ",
+	"-": "ー",
+}
+
+// romajiToHiragana converts the romaji s to hiragana. As in the input
+// methods, a doubled consonant gives a small tsu, and n before a consonant
+// gives n. It returns the hiragana for the longest prefix it can convert,
+// and the rest of s, which is the start of a syllable or not romaji.
+func romajiToHiragana(s string) (hira, rest string) {
+	var sb strings.Builder
+	for len(s) > 0 {
+		matched := false
+		for n := 4; n > 0; n-- {
+			if n > len(s) {
+				continue
+			}
+			if kana, ok := romaji[s[:n]]; ok {
+				sb.WriteString(kana)
+				s = s[n:]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		if len(s) < 2 {
+			break
+		}
+		switch c := s[0]; {
+		case c == 'n' && !strings.ContainsRune("aiueoy", rune(s[1])):
+			sb.WriteString("ん")
+			s = s[1:]
+		case c == s[1] && strings.ContainsRune("bcdfghjkmprstvwxyz", rune(c)):
+			sb.WriteString("っ")
+			s = s[1:]
+		default:
+			return sb.String(), s
+		}
+	}
+	return sb.String(), s
+}
+
+// migemoReadings returns the hiragana readings of the romaji s. An
+// unfinished syllable at the end of s gives a reading for each syllable it
+// may start. It returns nil if s is not romaji.
+func migemoReadings(s string) []string {
+	hira, rest := romajiToHiragana(strings.ToLower(s))
+	if rest == "" {
+		return []string{hira}
+	}
+	var readings []string
+	seen := map[string]bool{}
+	for key, kana := range romaji {
+		if strings.HasPrefix(key, rest) && !seen[kana] {
+			seen[kana] = true
+			readings = append(readings, hira+kana)
+		}
+	}
+	sort.Strings(readings)
+	return readings
+}
+
+// splitMigemoQuery splits a query like "kensakuKekka" into the words that
+// start with a capital letter, and at spaces.
+func splitMigemoQuery(query string) []string {
+	var words []string
+	for _, field := range strings.Fields(query) {
+		start := 0
+		for i, r := range field {
+			if i > 0 && unicode.IsUpper(r) {
+				words = append(words, field[start:i])
+				start = i
+			}
+		}
+		words = append(words, field[start:])
+	}
+	return words
+}
+
+// migemoDict holds the words of a dictionary of C/Migemo read for some
+// readings.
+type migemoDict map[string][]string // reading prefix -> words
+
+// loadMigemoDict reads the words for the readings that start with any of
+// prefixes from the dictionary at path. The dictionary has a line for each
+// reading, with the words after it separated by tabs, and the comments
+// start with ';'. It may be in UTF-8, EUC-JP or Shift_JIS.
+func loadMigemoDict(path string, prefixes []string) (migemoDict, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(b) {
+		d := Detect(b, []string{"euc-jp", "sjis"})
+		if valid := d.Encodings(); len(valid) > 0 {
+			e, _ := lookupEncoding(valid[0])
+			if b, err = e.NewDecoder().Bytes(b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	dict := migemoDict{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || line[0] == ';' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(fields[0], prefix) {
+				dict[prefix] = append(dict[prefix], fields[1:]...)
+			}
+		}
+	}
+	return dict, sc.Err()
+}
+
+// findMigemoDict returns the path of the dictionary for opts.
+func findMigemoDict(opts *Options) (string, error) {
+	if opts.MigemoDict != "" {
+		return opts.MigemoDict, nil
+	}
+	for _, path := range MigemoDictPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("migemo dictionary not found: give its path with --migemo-dict")
+}
+
+// migemoPattern expands the romaji query of opts to a regexp matching the
+// query, its hiragana, katakana and full-width forms, and the words of the
+// dictionary read so.
+func migemoPattern(opts *Options) (string, error) {
+	path, err := findMigemoDict(opts)
+	if err != nil {
+		return "", err
+	}
+	words := splitMigemoQuery(opts.Pattern)
+	var prefixes []string
+	readings := make([][]string, len(words))
+	for i, word := range words {
+		readings[i] = migemoReadings(word)
+		prefixes = append(prefixes, readings[i]...)
+	}
+	dict, err := loadMigemoDict(path, prefixes)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for i, word := range words {
+		alts := []string{word, toFullWidth(word)}
+		for _, hira := range readings[i] {
+			alts = append(alts, hira, toKatakana(hira))
+			alts = append(alts, dict[hira]...)
+		}
+		sb.WriteString(alternation(alts))
+	}
+	return sb.String(), nil
+}
+
+// alternation returns a regexp matching any of the strings alts, trying the
+// longer ones first.
+func alternation(alts []string) string {
+	seen := map[string]bool{}
+	var quoted []string
+	for _, alt := range alts {
+		if alt != "" && !seen[alt] {
+			seen[alt] = true
+			quoted = append(quoted, regexp.QuoteMeta(alt))
+		}
+	}
+	sort.Slice(quoted, func(i, j int) bool {
+		if len(quoted[i]) != len(quoted[j]) {
+			return len(quoted[i]) > len(quoted[j])
+		}
+		return quoted[i] < quoted[j]
+	})
+	return "(?:" + strings.Join(quoted, "|") + ")"
+}
+
+// toKatakana converts the hiragana in s to katakana.
+func toKatakana(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'ぁ' <= r && r <= 'ゖ' || r == 'ゝ' || r == 'ゞ' {
+			return r + ('ァ' - 'ぁ')
+		}
+		return r
+	}, s)
+}
+
+// toFullWidth converts the ASCII in s to full-width forms.
+func toFullWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		if '!' <= r && r <= '~' {
+			return r + ('！' - '!')
+		}
+		return r
+	}, s)
+}
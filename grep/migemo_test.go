@@ -0,0 +1,78 @@
+package grep
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMigemoReadings(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"kensaku", []string{"けんさく"}},
+		{"Gakkou", []string{"がっこう"}},
+		{"shinbunsha", []string{"しんぶんしゃ"}},
+		{"kon'ya", []string{"こんや"}},
+		{"kyouto", []string{"きょうと"}},
+		{"kensak", []string{"けんさか", "けんさき", "けんさく", "けんさけ", "けんさこ", "けんさきゃ", "けんさきゅ", "けんさきょ"}},
+		{"hon", []string{"ほな", "ほに", "ほぬ", "ほね", "ほの", "ほん", "ほにゃ", "ほにゅ", "ほにょ"}},
+		{"検索", nil},
+	}
+	for _, test := range tests {
+		got := migemoReadings(test.query)
+		sort.Strings(test.want)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: want %v but %v", test.query, test.want, got)
+		}
+	}
+	if got, want := splitMigemoQuery("kensakuKekka  foo"), []string{"kensaku", "Kekka", "foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v but %v", want, got)
+	}
+}
+
+func TestSearchMigemo(t *testing.T) {
+	dir := t.TempDir()
+	dict := "; comment\nけんさく\t検索\t研削\nけんさくする\t検索する\nけっか\t結果\nけんさ\t検査\n"
+	text := "検索\n研削\nけんさく\nケンサク\nkensaku\nｋｅｎｓａｋｕ\n検査\n検索結果\n"
+	for name, content := range map[string]string{"migemo-dict": dict, "a.txt": text} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := func(query string) string {
+		var found []string
+		s, err := New(&Options{
+			Pattern:    query,
+			Migemo:     true,
+			MigemoDict: filepath.Join(dir, "migemo-dict"),
+			Only:       true,
+			Sink: SinkFunc(func(m *Match) {
+				for _, mm := range m.Submatches {
+					found = append(found, string(m.Text[mm[0]:mm[1]]))
+				}
+			}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Search(context.Background(), []string{filepath.Join(dir, "a.txt")}); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(found, " ")
+	}
+	if got, want := search("kensaku"), "検索 研削 けんさく ケンサク kensaku ｋｅｎｓａｋｕ 検索"; got != want {
+		t.Errorf("want %q but %q", want, got)
+	}
+	if got, want := search("kensakuKekka"), "検索結果"; got != want {
+		t.Errorf("want %q but %q", want, got)
+	}
+	if _, err := New(&Options{Pattern: "x", Migemo: true, MigemoDict: filepath.Join(dir, "nothing")}); err == nil {
+		t.Error("want error for a missing dictionary")
+	}
+}
@@ -0,0 +1,223 @@
+package grep
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// needleKind tells how to find the start of the characters of an encoding.
+type needleKind int
+
+const (
+	kindAny       needleKind = iota // any byte may start a match
+	kindSJIS                        // Shift_JIS
+	kindEUCJP                       // EUC-JP
+	kindUTF16                       // UTF-16 of either byte order
+	kindUTF32                       // UTF-32 of either byte order
+	kindISO2022JP                   // ISO-2022-JP
+)
+
+// rawNeedle is a literal that every match of the pattern contains, encoded
+// in one encoding, so that the raw bytes of a file can be searched for it
+// before the file is decoded.
+type rawNeedle struct {
+	b     []byte     // the literal, or a part of it, in the encoding
+	kind  needleKind // how to check character boundaries
+	jis   bool       // b is in the two-byte mode of ISO-2022-JP
+	never bool       // the literal cannot be written in the encoding
+}
+
+// buildNeedles encodes lit into each of encodings. Encodings in which the
+// raw bytes cannot be searched reliably are left out.
+func buildNeedles(lit string, encodings []string) map[string]*rawNeedle {
+	needles := map[string]*rawNeedle{}
+	for _, enc := range encodings {
+		if n := buildNeedle(lit, enc); n != nil {
+			needles[enc] = n
+		}
+	}
+	return needles
+}
+
+func buildNeedle(lit string, enc string) *rawNeedle {
+	name := canonicalEncoding(enc)
+	if name == "utf-8" {
+		return &rawNeedle{b: []byte(lit)}
+	}
+	e, _ := lookupEncoding(enc)
+	if e == nil {
+		return nil
+	}
+	b, _, err := transform.Bytes(e.NewEncoder(), []byte(lit))
+	if err != nil {
+		return &rawNeedle{never: true}
+	}
+	n := &rawNeedle{b: b}
+	switch name {
+	case "utf-16le", "utf-16be":
+		n.kind = kindUTF16
+		return n
+	case "utf-32le", "utf-32be":
+		n.kind = kindUTF32
+		return n
+	case "iso-2022-jp":
+		// half-width katakana is encoded as full-width
+		if strings.IndexFunc(lit, func(r rune) bool { return r >= 0xff61 && r <= 0xff9f }) >= 0 {
+			return nil
+		}
+		n.kind = kindISO2022JP
+		n.b, n.jis = longestJISRun(b)
+		return n
+	case "shift_jis":
+		n.kind = kindSJIS
+	case "euc-jp":
+		n.kind = kindEUCJP
+	}
+	// a character written in several ways may not be found as encoded
+	if !isASCII(lit) {
+		amb := ambiguousRunes(name, e)
+		for _, r := range lit {
+			if amb[r] {
+				return nil
+			}
+		}
+	}
+	return n
+}
+
+// longestJISRun returns the longest run of b in one mode of ISO-2022-JP,
+// and whether the run is in the two-byte mode.
+func longestJISRun(b []byte) ([]byte, bool) {
+	var run []byte
+	var runJIS bool
+	jis := false
+	start := 0
+	for i := 0; i <= len(b); i++ {
+		if i < len(b) && b[i] != 0x1b {
+			continue
+		}
+		if i-start > len(run) {
+			run, runJIS = b[start:i], jis
+		}
+		if i+2 < len(b) {
+			jis = b[i+1] == '$'
+		}
+		i += 2
+		start = i + 1
+	}
+	return run, runJIS
+}
+
+var ambiguousCache sync.Map // canonical name -> map[rune]bool
+
+// ambiguousRunes returns the characters that more than one sequence of one
+// or two bytes decodes to in the encoding e.
+func ambiguousRunes(name string, e encoding.Encoding) map[rune]bool {
+	if v, ok := ambiguousCache.Load(name); ok {
+		return v.(map[rune]bool)
+	}
+	seen := map[rune]int{}
+	dec := e.NewDecoder()
+	add := func(seq []byte) {
+		t, err := dec.Bytes(seq)
+		if err != nil {
+			return
+		}
+		r, n := utf8.DecodeRune(t)
+		if r != utf8.RuneError && n == len(t) {
+			seen[r]++
+		}
+	}
+	for c1 := 0x80; c1 <= 0xff; c1++ {
+		add([]byte{byte(c1)})
+		for c2 := 0x40; c2 <= 0xff; c2++ {
+			add([]byte{byte(c1), byte(c2)})
+		}
+	}
+	amb := map[rune]bool{}
+	for r, n := range seen {
+		if n > 1 {
+			amb[r] = true
+		}
+	}
+	ambiguousCache.Store(name, amb)
+	return amb
+}
+
+// mayMatch reports whether the raw text b may have a match of the pattern
+// when it is decoded from enc. It reports true if it cannot tell.
+func (s *Searcher) mayMatch(b []byte, enc string) bool {
+	n, ok := s.needles[enc]
+	if !ok {
+		return true
+	}
+	if n.never {
+		return false
+	}
+	var sc charScanner
+	for off := 0; ; {
+		i := bytes.Index(b[off:], n.b)
+		if i < 0 {
+			return false
+		}
+		i += off
+		if sc.at(b, i, n) {
+			return true
+		}
+		off = i + 1
+	}
+}
+
+// charScanner walks the characters of an encoded text to check that the
+// offsets of the needle found in it are at the start of a character.
+type charScanner struct {
+	pos int  // offset of the next character
+	jis bool // in the two-byte mode of ISO-2022-JP
+}
+
+// at reports whether the needle n found at offset i of b starts a character
+// in the right mode. The offsets must be given in increasing order.
+func (sc *charScanner) at(b []byte, i int, n *rawNeedle) bool {
+	switch n.kind {
+	case kindAny:
+		return true
+	case kindUTF16:
+		return i%2 == 0
+	case kindUTF32:
+		return i%4 == 0
+	}
+	for sc.pos < i {
+		c := b[sc.pos]
+		switch n.kind {
+		case kindSJIS:
+			if (c >= 0x81 && c <= 0x9f) || (c >= 0xe0 && c <= 0xfc) {
+				sc.pos += 2
+			} else {
+				sc.pos++
+			}
+		case kindEUCJP:
+			if c == 0x8f {
+				sc.pos += 3
+			} else if c == 0x8e || c >= 0xa1 {
+				sc.pos += 2
+			} else {
+				sc.pos++
+			}
+		case kindISO2022JP:
+			if c == 0x1b && sc.pos+2 < len(b) {
+				sc.jis = b[sc.pos+1] == '$'
+				sc.pos += 3
+			} else if sc.jis && c != '\n' && c != '\r' {
+				sc.pos += 2
+			} else {
+				sc.pos++
+			}
+		}
+	}
+	return sc.pos == i && (n.kind != kindISO2022JP || sc.jis == n.jis)
+}
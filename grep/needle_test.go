@@ -0,0 +1,63 @@
+package grep
+
+import (
+	"testing"
+)
+
+func TestMayMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		enc     string
+		text    string
+		want    bool
+	}{
+		// "\x83\x41" is "ア" in Shift_JIS
+		{"A", "sjis", "\x83\x41\n", false},
+		{"A", "sjis", "\x83\x41A\n", true},
+		{"ア", "sjis", "x\x83\x41\n", true},
+		// "\xcb\xdc" is "本" in EUC-JP
+		{"本", "euc-jp", "\xb0\xcb\xdc\xa1\n", false},
+		{"本", "euc-jp", "\xb0\xa1\xcb\xdc\n", true},
+		{"日本", "euc-jp", "\xb0\xa1\xcb\xdc\n", false},
+		// "F|K\" is "日本" in JIS X 0208
+		{"日本", "iso-2022-jp", "F|K\\\n", false},
+		{"日本", "iso-2022-jp", "\x1b$BxF|K\\y\x1b(B\n", false},
+		{"日本", "iso-2022-jp", "\x1b$BF|K\\8l\x1b(B\n", true},
+		{"本", "utf-16le", "\x00\x2c\x67\x00", false},
+		{"本", "utf-16le", "\x2c\x67\x00\x00", true},
+		{"日本.", "sjis", "\x93\xfa\x96\x7b\n", true},
+		{"x[0-9]+本", "sjis", "x1\n", false},
+		{"😀", "sjis", "\x93\xfa\x96\x7b\n", false},
+	}
+	for _, test := range tests {
+		s, err := New(&Options{Pattern: test.pattern, Encodings: []string{test.enc}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.mayMatch([]byte(test.text), test.enc); got != test.want {
+			t.Errorf("%q in %s %q: want %v but %v", test.pattern, test.enc, test.text, test.want, got)
+		}
+	}
+}
+
+func TestRequiredLiteral(t *testing.T) {
+	tests := []struct {
+		pattern string
+		fixed   bool
+		want    string
+	}{
+		{"foo", false, "foo"},
+		{"a.c", true, "a.c"},
+		{"x+日本語[0-9]", false, "日本語"},
+		{"(foo|bar)baz", false, "baz"},
+		{"(foo)+", false, "foo"},
+		{"(?i)foo", false, ""},
+		{"foo|bar", false, ""},
+		{"a*", false, ""},
+	}
+	for _, test := range tests {
+		if got := requiredLiteral(&Options{Pattern: test.pattern, Fixed: test.fixed}); got != test.want {
+			t.Errorf("%q: want %q but %q", test.pattern, test.want, got)
+		}
+	}
+}
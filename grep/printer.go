@@ -0,0 +1,287 @@
+package grep
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Printer is a Sink that writes the results to an output.
+type Printer interface {
+	Sink
+
+	// Finish is called once after the search has completed.
+	Finish(st *Stats)
+}
+
+// Stats holds the totals of a search.
+type Stats struct {
+	Matches      int64 // number of matching lines, or of matches with Options.Only or Options.CountMatches
+	Files        int64 // number of files searched
+	MatchedFiles int64 // number of files with matching lines
+}
+
+var printers = map[string]func(w io.Writer, opts *Options) Printer{
+	"plain":   newPlainPrinter,
+	"color":   newColorPrinter,
+	"json":    newJSONPrinter,
+	"vimgrep": newVimgrepPrinter,
+}
+
+// NewPrinter returns the Printer for the output format name writing to w.
+func NewPrinter(name string, w io.Writer, opts *Options) (Printer, error) {
+	newPrinter, ok := printers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format: %s", name)
+	}
+	if name == "vimgrep" && opts.RawOutput {
+		// the columns are counted in the decoded text
+		return nil, errors.New("vimgrep format cannot be used with raw output")
+	}
+	o := *opts
+	o.setDefaults()
+	return newPrinter(w, &o), nil
+}
+
+// Formats returns the names of the output formats.
+func Formats() []string {
+	var names []string
+	for name := range printers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// palette is the set of escape sequences used to decorate text output.
+type palette struct {
+	path  string
+	line  string
+	sep   string
+	match string
+	reset string
+}
+
+// textPrinter writes the results as text in the format of grep.
+type textPrinter struct {
+	opts     *Options
+	w        io.Writer
+	colors   palette
+	buf      bytes.Buffer
+	file     *File
+	eol      []byte // line terminator of the text in the encoding of the file
+	printed  bool
+	lastPath string
+	lastLine int
+
+	inventory map[string]*inventoryRow // totals of Options.Inventory by encoding
+}
+
+func newPlainPrinter(w io.Writer, opts *Options) Printer {
+	return &textPrinter{opts: opts, w: w}
+}
+
+func newColorPrinter(w io.Writer, opts *Options) Printer {
+	return &textPrinter{opts: opts, w: w, colors: palette{
+		path:  cMAGENTA,
+		line:  cGREEN,
+		sep:   cCYAN,
+		match: cRED,
+		reset: cRESET,
+	}}
+}
+
+// vimgrepPrinter writes a line of file:line:column:text for each match, which
+// is the format of 'grepformat' in Vim. The column is counted in characters,
+// so it cannot be used with Options.RawOutput.
+type vimgrepPrinter struct {
+	textPrinter
+}
+
+func newVimgrepPrinter(w io.Writer, opts *Options) Printer {
+	return &vimgrepPrinter{textPrinter{opts: opts, w: w}}
+}
+
+func (p *vimgrepPrinter) Match(m *Match) {
+	if m.Context {
+		return
+	}
+	if len(m.Submatches) == 0 {
+		p.writeMatch(m, 1)
+		return
+	}
+	col, prev := 1, 0
+	for _, mm := range m.Submatches {
+		col += utf8.RuneCount(m.Text[prev:mm[0]])
+		prev = mm[0]
+		p.writeMatch(m, col)
+	}
+}
+
+func (p *vimgrepPrinter) writeMatch(m *Match, col int) {
+	p.writeEncoding(m.Encoding, m.BOM)
+	p.buf.WriteString(m.Path)
+	p.buf.WriteByte(':')
+	p.writeInt(m.Line)
+	p.buf.WriteByte(':')
+	p.writeInt(col)
+	p.buf.WriteByte(':')
+	p.buf.Write(m.Text)
+	p.writeEOL()
+}
+
+func (p *textPrinter) BeginFile(f *File) {
+	p.file = f
+	p.eol = nil
+	if p.opts.RawOutput && !asciiCompatible(f.Encoding) {
+		if e, _ := lookupEncoding(f.Encoding); e != nil {
+			eol := byte('\n')
+			if p.opts.ZeroData {
+				eol = 0
+			}
+			p.eol = encodeNoFlush(e, []byte{eol})
+		}
+	}
+}
+
+func (p *textPrinter) Match(m *Match) {
+	o := p.opts
+	if o.Only {
+		for _, mm := range m.Submatches {
+			p.writeEncoding(m.Encoding, m.BOM)
+			if o.Number {
+				p.writePrefix(m.Path, m.Line, mm[0]+1, false)
+			}
+			p.writeText(m.Text[mm[0]:mm[1]], [][]int{{0, mm[1] - mm[0]}})
+		}
+		return
+	}
+	if (o.Before > 0 || o.After > 0) && p.printed && (m.Path != p.lastPath || m.Line != p.lastLine+1) {
+		p.buf.WriteString("---\n")
+	}
+	p.printed = true
+	p.lastPath, p.lastLine = m.Path, m.Line
+	p.writeEncoding(m.Encoding, m.BOM)
+	if o.Number || !p.file.Single {
+		p.writePrefix(m.Path, m.Line, m.Column, m.Context)
+	}
+	p.writeText(m.Text, m.Submatches)
+}
+
+func (p *textPrinter) EndFile(f *File) {
+	o := p.opts
+	if f.Report != nil {
+		p.writeReport(f)
+	} else if o.List {
+		if f.Matched {
+			p.writeEncoding(f.Encoding, f.BOM)
+			p.buf.WriteString(f.Path)
+			p.writeEOL()
+		}
+	} else if o.Count && !o.CountTotal {
+		p.writeEncoding(f.Encoding, f.BOM)
+		if !f.Single {
+			p.buf.WriteString(p.colors.path + f.Path + p.colors.reset)
+			if o.ZeroFile {
+				p.buf.WriteByte(0)
+			} else {
+				p.buf.WriteString(p.colors.sep + o.Separator + p.colors.reset)
+			}
+		}
+		p.writeInt(int(f.Count))
+		p.writeEOL()
+	}
+	if p.buf.Len() > 0 {
+		p.w.Write(p.buf.Bytes())
+		p.buf.Reset()
+	}
+}
+
+func (p *textPrinter) Finish(st *Stats) {
+	if p.opts.CountTotal && !p.opts.List {
+		fmt.Fprintln(p.w, st.Matches)
+	}
+	if p.inventory != nil {
+		p.writeInventory()
+	}
+	// the printer may be used for another search
+	p.printed, p.lastPath, p.lastLine = false, "", 0
+	p.inventory = nil
+}
+
+func (p *textPrinter) writePrefix(path string, line, column int, context bool) {
+	o := p.opts
+	lc := o.Separator
+	if context {
+		lc = "-"
+	}
+	p.buf.WriteString(p.colors.path + path + p.colors.reset)
+	if o.ZeroFile {
+		p.buf.WriteByte(0)
+	} else {
+		p.buf.WriteString(o.Separator)
+	}
+	p.buf.WriteString(p.colors.line)
+	p.writeInt(line)
+	if o.Column && column > 0 {
+		p.buf.WriteByte(':')
+		p.writeInt(column)
+	}
+	p.buf.WriteString(p.colors.sep + lc + p.colors.reset)
+}
+
+// writeEncoding writes the encoding and the BOM state of a file in brackets,
+// like "[sjis] " or "[utf-8,bom] ", if Options.ShowEncoding is set.
+func (p *textPrinter) writeEncoding(enc string, bom []byte) {
+	if !p.opts.ShowEncoding {
+		return
+	}
+	p.buf.WriteString(p.colors.sep + "[" + enc)
+	if len(bom) > 0 {
+		p.buf.WriteString(",bom")
+	}
+	p.buf.WriteString("]" + p.colors.reset + " ")
+}
+
+func (p *textPrinter) writeText(text []byte, submatches [][]int) {
+	if p.colors.match == "" || len(submatches) == 0 {
+		p.buf.Write(text)
+		p.writeTextEOL()
+		return
+	}
+	prev := 0
+	for _, mm := range submatches {
+		p.buf.Write(text[prev:mm[0]])
+		p.buf.WriteString(p.colors.match)
+		p.buf.Write(text[mm[0]:mm[1]])
+		p.buf.WriteString(p.colors.reset)
+		prev = mm[1]
+	}
+	p.buf.Write(text[prev:])
+	p.writeTextEOL()
+}
+
+func (p *textPrinter) writeTextEOL() {
+	if p.eol != nil {
+		p.buf.Write(p.eol)
+	} else {
+		p.writeEOL()
+	}
+}
+
+func (p *textPrinter) writeInt(n int) {
+	var tmp [20]byte
+	p.buf.Write(strconv.AppendInt(tmp[:0], int64(n), 10))
+}
+
+func (p *textPrinter) writeEOL() {
+	if p.opts.ZeroData {
+		p.buf.WriteByte(0)
+	} else {
+		p.buf.WriteByte('\n')
+	}
+}
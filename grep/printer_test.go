@@ -0,0 +1,100 @@
+package grep
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrinters(t *testing.T) {
+	m := &Match{
+		Path:       "a.txt",
+		Line:       3,
+		Column:     3,
+		Encoding:   "sjis",
+		Text:       []byte("x foo y"),
+		Submatches: [][]int{{2, 5}},
+	}
+	tests := []struct {
+		format string
+		opts   Options
+		want   string
+	}{
+		{"plain", Options{}, "a.txt:3:x foo y\n"},
+		{"plain", Options{Column: true, Separator: "|"}, "a.txt|3:3|x foo y\n"},
+		{"plain", Options{ZeroFile: true, ZeroData: true}, "a.txt\x003:x foo y\x00"},
+		{"plain", Options{Only: true, Number: true}, "a.txt:3:foo\n"},
+		{"plain", Options{ShowEncoding: true}, "[sjis] a.txt:3:x foo y\n"},
+		{"vimgrep", Options{Separator: "|"}, "a.txt:3:3:x foo y\n"},
+		{"color", Options{}, cMAGENTA + "a.txt" + cRESET + ":" + cGREEN + "3" + cCYAN + ":" + cRESET + "x " + cRED + "foo" + cRESET + " y\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		p, err := NewPrinter(test.format, &buf, &test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := &File{Path: m.Path, Matched: true}
+		p.BeginFile(f)
+		p.Match(m)
+		p.EndFile(f)
+		if buf.String() != test.want {
+			t.Errorf("%s %+v: want %q but %q", test.format, test.opts, test.want, buf.String())
+		}
+	}
+
+	if _, err := NewPrinter("unknown", nil, &Options{}); err == nil {
+		t.Error("should be failed for unknown format")
+	}
+	if _, err := NewPrinter("vimgrep", nil, &Options{RawOutput: true}); err == nil {
+		t.Error("should be failed for vimgrep with raw output")
+	}
+}
+
+func TestVimgrepPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPrinter("vimgrep", &buf, &Options{After: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &File{Path: "a.txt", Matched: true, Single: true}
+	p.BeginFile(f)
+	p.Match(&Match{Path: f.Path, Line: 1, Text: []byte("日本語 foo 日本"), Submatches: [][]int{{0, 6}, {14, 20}}})
+	p.Match(&Match{Path: f.Path, Line: 2, Text: []byte("日本"), Context: true})
+	p.EndFile(f)
+	want := "a.txt:1:1:日本語 foo 日本\na.txt:1:9:日本語 foo 日本\n"
+	if buf.String() != want {
+		t.Errorf("want %q but %q", want, buf.String())
+	}
+}
+
+func TestJSONPrinter(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPrinter("json", &buf, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &File{Path: "a\xff.txt", Encoding: "sjis", BOM: bomUTF8, Matched: true, Count: 1}
+	p.BeginFile(f)
+	p.Match(&Match{Path: f.Path, Line: 1, Text: []byte("abc"), Encoding: "sjis", Context: true})
+	p.Match(&Match{Path: f.Path, Line: 2, Offset: 4, Column: 3, Text: []byte("x 日本"), Encoding: "sjis", BOM: bomUTF8, Submatches: [][]int{{2, 8}}})
+	p.EndFile(f)
+	p.Finish(&Stats{Matches: 1, Files: 2, MatchedFiles: 1})
+
+	want := []string{
+		`{"type":"begin","data":{"path":{"bytes":"Yf8udHh0"}}}`,
+		`{"type":"context","data":{"path":{"bytes":"Yf8udHh0"},"text":{"text":"abc"},"line_number":1,"offset":0,"column":0,"submatches":[],"encoding":"sjis","bom":false}}`,
+		`{"type":"match","data":{"path":{"bytes":"Yf8udHh0"},"text":{"text":"x 日本"},"line_number":2,"offset":4,"column":3,"submatches":[{"match":{"text":"日本"},"start":2,"end":8}],"encoding":"sjis","bom":true}}`,
+		`{"type":"end","data":{"path":{"bytes":"Yf8udHh0"},"encoding":"sjis","bom":true,"matched":true,"count":1}}`,
+		`{"type":"summary","data":{"matches":1,"files":2,"matched_files":1}}`,
+	}
+	got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("want %d events but %d: %q", len(want), len(got), buf.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want %s but %s", want[i], got[i])
+		}
+	}
+}
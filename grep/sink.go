@@ -0,0 +1,66 @@
+package grep
+
+// Match is a line reported by a Searcher.
+type Match struct {
+	Path       string  // path of the file
+	Line       int     // line number, starting at 1
+	Offset     int64   // byte offset of the line in the decoded text
+	Column     int     // column of the first match in bytes, starting at 1 (0 if none)
+	Encoding   string  // encoding detected for the file
+	BOM        []byte  // byte order mark stripped from the file
+	Text       []byte  // text of the line in UTF-8 (as is with Options.RawOutput), without the line terminator
+	Submatches [][]int // spans of the matches in Text
+	Context    bool    // the line is context around a match, not a match
+}
+
+// File is a file reported by a Searcher.
+type File struct {
+	Path     string  // path of the file
+	Encoding string  // encoding detected for the file
+	BOM      []byte  // byte order mark stripped from the file
+	Matched  bool    // the file has matching lines
+	Count    int64   // number of matching lines, or of matches with Options.CountMatches
+	Single   bool    // the file is the only input, so its name may be omitted
+	Report   *Report // encoding report of the file with Options.Inventory
+}
+
+// Sink receives the results of a Searcher. BeginFile and EndFile are called
+// around the matches of each file that has results, and calls for different
+// files are never interleaved. The values passed to a Sink may be reused
+// after the call returns.
+type Sink interface {
+	BeginFile(f *File)
+	Match(m *Match)
+	EndFile(f *File)
+}
+
+// SinkFunc is a Sink that only receives matches.
+type SinkFunc func(m *Match)
+
+// BeginFile implements Sink.
+func (fn SinkFunc) BeginFile(f *File) {}
+
+// Match implements Sink.
+func (fn SinkFunc) Match(m *Match) {
+	fn(m)
+}
+
+// EndFile implements Sink.
+func (fn SinkFunc) EndFile(f *File) {}
+
+func (a *grepArg) addMatch(line, offset int, text []byte, submatches [][]int, context bool) {
+	m := Match{
+		Path:       a.output,
+		Line:       line,
+		Offset:     int64(offset),
+		Encoding:   a.enc,
+		BOM:        a.bom,
+		Text:       append([]byte(nil), text...),
+		Submatches: submatches,
+		Context:    context,
+	}
+	if len(submatches) > 0 {
+		m.Column = submatches[0][0] + 1
+	}
+	a.matches = append(a.matches, m)
+}
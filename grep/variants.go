@@ -0,0 +1,143 @@
+package grep
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// kanjiVariants are the groups of kanji taken as equal by
+// Options.FoldKanjiVariants, separated by spaces. The first of a group is
+// the form the others fold to. Most are the old forms (kyujitai) of the new
+// ones (shinjitai); the rest are variants common in names. The CJK
+// compatibility ideographs fold to the kanji they stand for besides.
+const kanjiVariants = `
+高髙 崎﨑嵜碕 吉𠮷 斎齋齊斉 辺邊邉 浜濱濵 島嶋嶌 桑桒 徳德 館舘 蔵藏
+亜亞 悪惡 圧壓 囲圍 医醫 為爲 壱壹 隠隱 栄榮 営營 衛衞 駅驛 円圓 塩鹽 縁緣
+応應 欧歐 殴毆 桜櫻 奥奧 横橫 温溫 穏穩 仮假 価價 画畫 会會 絵繪 拡擴 覚覺
+学學 岳嶽 楽樂 渇渴 巻卷 陥陷 勧勸 寛寬 関關 歓歡 観觀 気氣 帰歸 亀龜 偽僞
+戯戲 犠犧 旧舊 拠據 挙擧 峡峽 挟挾 狭狹 暁曉 区區 駆驅 勲勳 径徑 茎莖 恵惠
+掲揭 渓溪 経經 蛍螢 軽輕 継繼 鶏鷄 芸藝 撃擊 県縣 剣劍 険險 圏圈 検檢 権權
+献獻 顕顯 験驗 厳嚴 効效 広廣 恒恆 鉱鑛 号號 国國 黒黑 済濟 砕碎 剤劑 雑雜
+参參 桟棧 蚕蠶 惨慘 賛贊 残殘 糸絲 歯齒 児兒 辞辭 湿濕 実實 写寫 釈釋 寿壽
+収收 従從 渋澁 獣獸 縦縱 粛肅 処處 緒緖 叙敍 奨奬 将將 焼燒 称稱 証證 乗乘
+剰剩 壌壤 嬢孃 条條 浄淨 畳疊 穣穰 譲讓 醸釀 嘱囑 触觸 寝寢 慎愼 真眞 尽盡
+図圖 粋粹 酔醉 随隨 髄髓 数數 枢樞 声聲 静靜 摂攝 窃竊 専專 浅淺 戦戰 践踐
+銭錢 潜潛 繊纖 禅禪 双雙 壮壯 争爭 荘莊 捜搜 挿插 巣巢 装裝 騒騷 増增 臓臟
+続續 堕墮 対對 体體 帯帶 滞滯 台臺 滝瀧 択擇 沢澤 担擔 単單 胆膽 団團 弾彈
+断斷 遅遲 昼晝 虫蟲 鋳鑄 庁廳 聴聽 鎮鎭 逓遞 鉄鐵 転轉 点點 伝傳 党黨 盗盜
+灯燈 当當 闘鬭 独獨 読讀 届屆 弐貳 悩惱 脳腦 廃廢 拝拜 売賣 麦麥 発發 髪髮
+抜拔 蛮蠻 秘祕 払拂 仏佛 並竝 変變 弁辨瓣辯 舗舖 宝寶 豊豐 没沒 翻飜 万萬
+満滿 黙默 訳譯 薬藥 予豫 余餘 与與 誉譽 揺搖 様樣 謡謠 来來 頼賴 乱亂 覧覽
+竜龍 両兩 猟獵 緑綠 塁壘 涙淚 励勵 礼禮 霊靈 齢齡 恋戀 炉爐 労勞 楼樓 郎郞
+禄祿 録錄 湾灣
+`
+
+// variantTable maps the kanji to the form of their group they fold to.
+// The forms folded to are not in it.
+type variantTable map[rune]rune
+
+func (t variantTable) canonical(r rune) rune {
+	if c, ok := t[r]; ok {
+		return c
+	}
+	return r
+}
+
+// add joins the kanji of group, and the groups they are in, into one that
+// folds to the form of the first.
+func (t variantTable) add(group []rune) {
+	root := t.canonical(group[0])
+	for _, r := range group[1:] {
+		c := t.canonical(r)
+		if c == root {
+			continue
+		}
+		for k, v := range t {
+			if v == c {
+				t[k] = root
+			}
+		}
+		t[c] = root
+	}
+}
+
+// addGroups adds the groups of kanji separated by spaces in s. Anything
+// after '#' on a line is a comment.
+func (t variantTable) addGroups(s string) {
+	for _, line := range strings.Split(s, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, group := range strings.Fields(line) {
+			if runes := []rune(group); len(runes) > 1 {
+				t.add(runes)
+			}
+		}
+	}
+}
+
+var (
+	bundledVariantsOnce sync.Once
+	bundledVariants     variantTable
+)
+
+// newVariantTable returns the bundled table of kanji variants, extended
+// with the groups in the file at path if it is not empty. The file is in
+// UTF-8, with the same format as kanjiVariants.
+func newVariantTable(path string) (variantTable, error) {
+	bundledVariantsOnce.Do(func() {
+		bundledVariants = variantTable{}
+		for _, rng := range [][2]rune{{0xf900, 0xfaff}, {0x2f800, 0x2fa1f}} {
+			for r := rng[0]; r <= rng[1]; r++ {
+				s := norm.NFC.String(string(r))
+				if c, size := utf8.DecodeRuneInString(s); size == len(s) && c != r && unicode.Is(unicode.Han, c) {
+					bundledVariants[r] = c
+				}
+			}
+		}
+		bundledVariants.addGroups(kanjiVariants)
+	})
+	t := make(variantTable, len(bundledVariants))
+	for k, v := range bundledVariants {
+		t[k] = v
+	}
+	if path == "" {
+		return t, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		t.addGroups(sc.Text())
+	}
+	return t, sc.Err()
+}
+
+// variantFolder folds the kanji of a variantTable.
+type variantFolder struct {
+	table variantTable
+}
+
+func (f *variantFolder) next(b []byte) (int, []byte) {
+	if b[0] < utf8.RuneSelf {
+		return 1, nil
+	}
+	r, size := utf8.DecodeRune(b)
+	if c, ok := f.table[r]; ok {
+		return size, []byte(string(c))
+	}
+	return size, nil
+}
+
+func (f *variantFolder) runes() map[rune]rune {
+	return f.table
+}
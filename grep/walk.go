@@ -0,0 +1,469 @@
+package grep
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	ignore "github.com/sabhiram/go-gitignore"
+	"github.com/saracen/walker"
+)
+
+// DefaultExclude is the exclude pattern used when Options.Exclude is empty.
+const DefaultExclude = `(^|\/)\.git$|(^|\/)\.svn$|(^|\/)\.hg$|` +
+	`\.o$|\.obj$|\.a$|\.rlib$|\.so$|\.dll$|\.dylib$|\.lib$|\.class$|\.jar$|\.war$|\.pyc$|\.pyo$|\.wasm$|` +
+	`\.[jJ][pP][gG]$|\.gif$|\.png$|\.bmp$|\.ico$|\.tiff?$|\.webp$|\.svg$|` +
+	`\.gz$|\.zip$|\.tar$|\.bz2$|\.xz$|\.7z$|\.rar$|\.zst$|` +
+	`\.pdf$|\.doc[x]?$|\.xls[x]?$|\.ppt[x]?$|` +
+	`\.mp[34]$|\.avi$|\.mov$|\.wmv$|\.flv$|\.webm$|\.mkv$|\.wav$|\.flac$|\.ogg$|` +
+	`\.ttf$|\.otf$|\.woff2?$|\.eot$|` +
+	`\.[eE][xX][eE]~?$|(^|\/)tags$|` +
+	`(^|\/)node_modules$|(^|\/)__pycache__$|(^|\/)site-packages$|` +
+	`(^|\/)\.tox$|(^|\/)\.mypy_cache$|(^|\/)\.pytest_cache$`
+
+// excludeExts is a fast extension-based lookup used when exclude is the default pattern.
+var excludeExts = map[string]bool{
+	".o": true, ".obj": true, ".a": true, ".rlib": true,
+	".so": true, ".dll": true, ".dylib": true, ".lib": true,
+	".class": true, ".jar": true, ".war": true, ".pyc": true, ".pyo": true, ".wasm": true,
+	".jpg": true, ".jpeg": true, ".gif": true, ".png": true, ".bmp": true,
+	".ico": true, ".tif": true, ".tiff": true, ".webp": true, ".svg": true,
+	".gz": true, ".zip": true, ".tar": true, ".bz2": true, ".xz": true,
+	".7z": true, ".rar": true, ".zst": true,
+	".pdf": true, ".doc": true, ".docx": true, ".xls": true, ".xlsx": true,
+	".ppt": true, ".pptx": true,
+	".mp3": true, ".mp4": true, ".avi": true, ".mov": true, ".wmv": true,
+	".flv": true, ".webm": true, ".mkv": true, ".wav": true, ".flac": true, ".ogg": true,
+	".ttf": true, ".otf": true, ".woff": true, ".woff2": true, ".eot": true,
+	".exe": true,
+}
+
+// excludeDirs is a fast directory name lookup used when exclude is the default pattern.
+var excludeDirs = map[string]bool{
+	".git": true, ".svn": true, ".hg": true,
+	"node_modules": true, "__pycache__": true, "site-packages": true,
+	".tox": true, ".mypy_cache": true, ".pytest_cache": true,
+}
+
+// isDefaultExcluded performs fast exclusion checks using maps instead of regex.
+func isDefaultExcluded(path string, isDir bool) bool {
+	base := filepath.Base(path)
+	if isDir {
+		return excludeDirs[base]
+	}
+	if base == "tags" {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(base))
+	return excludeExts[ext]
+}
+
+type ignoreChecker struct {
+	dir string
+	gi  *ignore.GitIgnore
+}
+
+type gitIgnoreManager struct {
+	matchers sync.Map // dir path -> *ignore.GitIgnore (or nil)
+	gitRoots sync.Map // dir path -> bool
+	chains   sync.Map // dir path -> []ignoreChecker (cached ancestor chain)
+}
+
+func (g *gitIgnoreManager) loadGitIgnore(dir string) *ignore.GitIgnore {
+	if v, ok := g.matchers.Load(dir); ok {
+		gi, _ := v.(*ignore.GitIgnore)
+		return gi
+	}
+	gi, err := ignore.CompileIgnoreFile(dir + "/.gitignore")
+	if err != nil {
+		g.matchers.Store(dir, (*ignore.GitIgnore)(nil))
+		return nil
+	}
+	g.matchers.Store(dir, gi)
+	return gi
+}
+
+func (g *gitIgnoreManager) isGitRoot(dir string) bool {
+	if v, ok := g.gitRoots.Load(dir); ok {
+		return v.(bool)
+	}
+	_, err := os.Stat(dir + "/.git")
+	isRoot := err == nil
+	g.gitRoots.Store(dir, isRoot)
+	return isRoot
+}
+
+func (g *gitIgnoreManager) getChain(dir string) []ignoreChecker {
+	if v, ok := g.chains.Load(dir); ok {
+		return v.([]ignoreChecker)
+	}
+	var dirs []string
+	for d := dir; ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if g.isGitRoot(d) || d == filepath.Dir(d) {
+			break
+		}
+	}
+	var chain []ignoreChecker
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if gi := g.loadGitIgnore(dirs[i]); gi != nil {
+			chain = append(chain, ignoreChecker{dir: dirs[i], gi: gi})
+		}
+	}
+	g.chains.Store(dir, chain)
+	return chain
+}
+
+func (g *gitIgnoreManager) isIgnored(absPath string, isDir bool) bool {
+	dir := filepath.Dir(absPath)
+	chain := g.getChain(dir)
+	for _, c := range chain {
+		rel := absPath[len(c.dir)+1:]
+		if isDir {
+			rel += "/"
+		}
+		if c.gi.MatchesPath(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends arg into ch unless ctx is done. If ord is not nil, arg gets the
+// next sequence number of the walk.
+func send(ctx context.Context, ch chan *grepArg, arg *grepArg, ord *ordering) error {
+	if ord != nil {
+		arg.order = ord
+		arg.seq = ord.seq
+	}
+	select {
+	case ch <- arg:
+		if ord != nil {
+			ord.seq++
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// walkSorted is like walker.WalkWithContext, but it visits the entries of
+// each directory one by one in lexical order.
+func walkSorted(ctx context.Context, root string, walkFn func(pathname string, fi os.FileInfo) error) error {
+	fi, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+	if err = walkFn(root, fi); err == filepath.SkipDir {
+		return nil
+	}
+	if err != nil || !fi.IsDir() {
+		return err
+	}
+	return walkSortedDir(ctx, root, walkFn)
+}
+
+func walkSortedDir(ctx context.Context, dirname string, walkFn func(pathname string, fi os.FileInfo) error) error {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pathname := dirname + string(filepath.Separator) + name
+		fi, err := os.Lstat(pathname)
+		if err != nil {
+			continue
+		}
+		err = walkFn(pathname, fi)
+		if err == filepath.SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if err := walkSortedDir(ctx, pathname, walkFn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walk expands args and sends the files to search into ch. If ord is not nil,
+// the files are walked in lexical order. It stops when ctx is done.
+func (s *Searcher) walk(ctx context.Context, args []string, ch chan *grepArg, ord *ordering) error {
+	o := &s.opts
+	useDefaultExclude := s.ere == nil
+
+	globmask := ""
+	nargs := len(args)
+	for _, arg := range args {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		globmask = ""
+		root := ""
+		arg = strings.Trim(arg, `"`)
+		fi, err := os.Stat(arg)
+		if err == nil && fi.Mode().IsRegular() {
+			// existing files: emit grep directly.
+			if err := send(ctx, ch, s.newGrepArg(arg, fi.Size(), false), ord); err != nil {
+				return err
+			}
+			continue
+		} else if err == nil && fi.Mode().IsDir() {
+			// existing directories: no need to prepare extra for glob.
+		} else {
+			// otherwise: prepare glob with expand path.
+			root, globmask = prepareGlob(arg)
+		}
+		if root == "" {
+			path, _ := filepath.Abs(arg)
+			fi, err := os.Lstat(path)
+			if err != nil {
+				return fmt.Errorf("%s: No such file or directory", arg)
+			}
+			if !fi.IsDir() {
+				if fi.Size() == 0 {
+					continue
+				}
+				s.debug("search:", path)
+				if err := send(ctx, ch, s.newGrepArg(path, fi.Size(), nargs == 1), ord); err != nil {
+					return err
+				}
+				continue
+			} else {
+				root = path
+				if fi.IsDir() {
+					globmask = "**/*"
+				} else {
+					globmask = "**/" + globmask
+				}
+			}
+		}
+		if globmask == "" {
+			globmask = "."
+		}
+		globmask = filepath.ToSlash(filepath.Clean(globmask))
+		if o.Recursive {
+			if strings.Index(globmask, "/") > -1 {
+				globmask += "/"
+			} else {
+				globmask = "**/" + globmask
+			}
+		}
+
+		cc := []rune(globmask)
+		dirmask := ""
+		filemask := ""
+		for i := 0; i < len(cc); i++ {
+			if cc[i] == '*' {
+				if i < len(cc)-2 && cc[i+1] == '*' && cc[i+2] == '/' {
+					filemask += "(.*/)?"
+					dirmask = filemask
+					i += 2
+				} else {
+					filemask += "[^/]*"
+				}
+			} else {
+				c := cc[i]
+				if c == '/' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || 255 < c {
+					filemask += string(c)
+				} else {
+					filemask += fmt.Sprintf("[\\x%x]", c)
+				}
+				if c == '/' && dirmask == "" && strings.Index(filemask, "*") != -1 {
+					dirmask = filemask
+				}
+			}
+		}
+		if dirmask == "" {
+			dirmask = filemask
+		}
+		if len(filemask) > 0 && filemask[len(filemask)-1] == '/' {
+			if root == "" {
+				root = filemask
+			}
+			filemask += "[^/]*"
+		}
+		if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+			dirmask = "(?i:" + dirmask + ")"
+			filemask = "(?i:" + filemask + ")"
+		}
+
+		root = filepath.Clean(root)
+		if root == "." {
+			dirmask = "./" + dirmask
+			filemask = "./" + filemask
+		}
+
+		dre := regexp.MustCompile("^" + dirmask)
+		fre := regexp.MustCompile("^" + filemask + "$")
+
+		s.debug("dirmask:", dirmask)
+		s.debug("filemask:", filemask)
+		s.debug("root:", root)
+
+		absRoot, _ := filepath.Abs(root)
+		absRoot = filepath.ToSlash(absRoot)
+		var gim *gitIgnoreManager
+		if o.GitIgnore {
+			gim = &gitIgnoreManager{}
+		}
+
+		isAbsRoot := filepath.IsAbs(root)
+		walkFn := func(path string, mode os.FileInfo) error {
+			path = filepath.ToSlash(path)
+
+			base := path
+			if i := strings.LastIndexByte(path, '/'); i >= 0 {
+				base = path[i+1:]
+			}
+
+			isDir := mode.IsDir()
+
+			if o.SkipHidden && len(base) > 1 && base[0] == '.' {
+				if isDir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if gim != nil {
+				if isDir && base == ".git" {
+					if i := strings.LastIndexByte(path, '/'); i >= 0 {
+						var parentAbs string
+						if isAbsRoot {
+							parentAbs = path[:i]
+						} else {
+							parentAbs = absRoot + "/" + path[:i]
+						}
+						gim.gitRoots.Store(parentAbs, true)
+					}
+					return filepath.SkipDir
+				}
+
+				var absPath string
+				if isAbsRoot {
+					absPath = path
+				} else {
+					absPath = absRoot + "/" + path
+				}
+				if gim.isIgnored(absPath, isDir) {
+					if isDir {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+
+			if useDefaultExclude {
+				if isDir {
+					if excludeDirs[base] {
+						return filepath.SkipDir
+					}
+				} else {
+					if base == "tags" {
+						return nil
+					}
+					if dot := strings.LastIndexByte(base, '.'); dot >= 0 {
+						if excludeExts[strings.ToLower(base[dot:])] {
+							return nil
+						}
+					}
+				}
+			} else if s.ere.MatchString(path) {
+				if isDir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if mode.IsDir() {
+				if path == "." || o.Recursive || len(path) <= len(root) || dre.MatchString(path+"/") {
+					return nil
+				}
+				return filepath.SkipDir
+			}
+
+			if fre.MatchString(path) && mode.Mode().IsRegular() {
+				s.debug("search:", path)
+				return send(ctx, ch, s.newGrepArg(path, mode.Size(), false), ord)
+			}
+			return nil
+		}
+		if ord != nil {
+			err = walkSorted(ctx, root, walkFn)
+		} else {
+			err = walker.WalkWithContext(ctx, root, walkFn)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			s.debug(err.Error())
+		}
+	}
+	return nil
+}
+
+var envre = regexp.MustCompile(`^(\$[a-zA-Z][a-zA-Z0-9_]+|\$\([a-zA-Z][a-zA-Z0-9_]+\))$`)
+
+// prepareGlob prepares glob parameters with expanding `*`, `~` and environment
+// variables in path.
+func prepareGlob(arg string) (root, globmask string) {
+	slashed := filepath.ToSlash(arg)
+	volume := filepath.VolumeName(slashed)
+	if volume != "" {
+		slashed = slashed[len(volume):]
+	}
+	for n, i := range strings.Split(slashed, "/") {
+		if root == "" && strings.Index(i, "*") != -1 {
+			if globmask == "" {
+				root = "."
+			} else {
+				root = filepath.ToSlash(globmask)
+			}
+		}
+		if n == 0 && i == "~" {
+			if runtime.GOOS == "windows" {
+				i = os.Getenv("USERPROFILE")
+			} else {
+				i = os.Getenv("HOME")
+			}
+		}
+		if envre.MatchString(i) {
+			i = strings.Trim(strings.Trim(os.Getenv(i[1:]), "()"), `"`)
+		}
+
+		globmask = filepath.Join(globmask, i)
+		if n == 0 {
+			if runtime.GOOS == "windows" && filepath.VolumeName(i) != "" {
+				globmask = i + "/"
+			} else if len(globmask) == 0 {
+				globmask = "/"
+			}
+		}
+	}
+	if volume != "" {
+		root = filepath.Join(volume, root)
+		globmask = filepath.Join(volume, globmask)
+	}
+	return root, globmask
+}
@@ -1,28 +1,19 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"regexp"
-	"regexp/syntax"
-	"runtime"
 	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"syscall"
-	"unicode/utf8"
+	"time"
 
 	"github.com/mattn/go-colorable"
 	"github.com/mattn/go-isatty"
-	"github.com/mattn/jvgrep/v5/mmap"
-	ignore "github.com/sabhiram/go-gitignore"
-	"github.com/saracen/walker"
+	"github.com/mattn/jvgrep/v5/grep"
 	"golang.org/x/net/html/charset"
 	"golang.org/x/text/transform"
 )
@@ -33,1076 +24,30 @@ const (
 	revision = "HEAD"
 )
 
-const (
-	cMAGENTA = "\x1b[35;1m" // Color magenta
-	cCYAN    = "\x1b[36;1m" // Color cyan
-	cGREEN   = "\x1b[32;1m" // Color green
-	cRED     = "\x1b[31;1m" // Color red
-	cRESET   = "\x1b[39;0m" // Color reset
-)
-
-var encodings = []string{
-	"iso-2022-jp",
-	"euc-jp",
-	"utf-8",
-	"sjis",
-	"utf-16le",
-	"utf-16be",
-}
-
 var stdout = colorable.NewColorableStdout()
 
-// GrepArg mean arguments to Grep.
-type GrepArg struct {
-	pattern interface{}
-	input   interface{}
-	size    int64
-	single  bool
-	atty    bool
-	bom     []byte
-	ascii   bool
-	output  string
-	needle  []byte
-	folded  []byte
-	buf     bytes.Buffer
-}
-
-func (a *GrepArg) writeStr(s string) {
-	a.buf.WriteString(s)
-}
-
-func (a *GrepArg) writeBytes(b []byte) {
-	a.buf.Write(b)
-}
-
-func (a *GrepArg) writeByte(b byte) {
-	a.buf.WriteByte(b)
-}
-
-func (a *GrepArg) writeInt(n int) {
-	var tmp [20]byte
-	a.buf.Write(strconv.AppendInt(tmp[:0], int64(n), 10))
-}
-
-func (a *GrepArg) writeLine(s string) {
-	a.buf.WriteString(s)
-	if zeroData {
-		a.buf.WriteByte(0)
-	} else {
-		a.buf.WriteByte('\n')
-	}
-}
-
-const excludeDefaults = `(^|\/)\.git$|(^|\/)\.svn$|(^|\/)\.hg$|` +
-	`\.o$|\.obj$|\.a$|\.rlib$|\.so$|\.dll$|\.dylib$|\.lib$|\.class$|\.jar$|\.war$|\.pyc$|\.pyo$|\.wasm$|` +
-	`\.[jJ][pP][gG]$|\.gif$|\.png$|\.bmp$|\.ico$|\.tiff?$|\.webp$|\.svg$|` +
-	`\.gz$|\.zip$|\.tar$|\.bz2$|\.xz$|\.7z$|\.rar$|\.zst$|` +
-	`\.pdf$|\.doc[x]?$|\.xls[x]?$|\.ppt[x]?$|` +
-	`\.mp[34]$|\.avi$|\.mov$|\.wmv$|\.flv$|\.webm$|\.mkv$|\.wav$|\.flac$|\.ogg$|` +
-	`\.ttf$|\.otf$|\.woff2?$|\.eot$|` +
-	`\.[eE][xX][eE]~?$|(^|\/)tags$|` +
-	`(^|\/)node_modules$|(^|\/)__pycache__$|(^|\/)site-packages$|` +
-	`(^|\/)\.tox$|(^|\/)\.mypy_cache$|(^|\/)\.pytest_cache$`
-
-// excludeExts is a fast extension-based lookup used when exclude is the default pattern.
-var excludeExts = map[string]bool{
-	".o": true, ".obj": true, ".a": true, ".rlib": true,
-	".so": true, ".dll": true, ".dylib": true, ".lib": true,
-	".class": true, ".jar": true, ".war": true, ".pyc": true, ".pyo": true, ".wasm": true,
-	".jpg": true, ".jpeg": true, ".gif": true, ".png": true, ".bmp": true,
-	".ico": true, ".tif": true, ".tiff": true, ".webp": true, ".svg": true,
-	".gz": true, ".zip": true, ".tar": true, ".bz2": true, ".xz": true,
-	".7z": true, ".rar": true, ".zst": true,
-	".pdf": true, ".doc": true, ".docx": true, ".xls": true, ".xlsx": true,
-	".ppt": true, ".pptx": true,
-	".mp3": true, ".mp4": true, ".avi": true, ".mov": true, ".wmv": true,
-	".flv": true, ".webm": true, ".mkv": true, ".wav": true, ".flac": true, ".ogg": true,
-	".ttf": true, ".otf": true, ".woff": true, ".woff2": true, ".eot": true,
-	".exe": true,
-}
-
-// excludeDirs is a fast directory name lookup used when exclude is the default pattern.
-var excludeDirs = map[string]bool{
-	".git": true, ".svn": true, ".hg": true,
-	"node_modules": true, "__pycache__": true, "site-packages": true,
-	".tox": true, ".mypy_cache": true, ".pytest_cache": true,
-}
-
-// isDefaultExcluded performs fast exclusion checks using maps instead of regex.
-func isDefaultExcluded(path string, isDir bool) bool {
-	base := filepath.Base(path)
-	if isDir {
-		return excludeDirs[base]
-	}
-	if base == "tags" {
-		return true
-	}
-	ext := strings.ToLower(filepath.Ext(base))
-	return excludeExts[ext]
-}
-
 var (
-	encs         string       // encodings
-	exclude      string       // exclude pattern
-	fixed        bool         // fixed search
-	ignorecase   bool         // ignorecase
-	ignorebinary bool         // ignorebinary
-	infile       string       // input filename
-	invert       bool         // invert search
-	only         bool         // show only matched
-	list         bool         // show the list matches
-	number       bool         // show line number
-	recursive    bool         // recursive search
-	verbose      bool         // verbose output
-	utf8out      bool         // output utf-8 strings
-	perl         bool         // perl regexp syntax
-	basic        bool         // basic regexp syntax
-	oc           io.Writer    // output encoder
-	color        string       // color operation
-	cwd, _       = os.Getwd() // current directory
-	zeroFile     bool         // write \0 after the filename
-	zeroData     bool         // write \0 after the match
-	allowTty     bool         // allow to search tty
-	countMatch   int64        // count of matches
-	count        bool         // count of matches
-	column       bool         // show column
-	fullpath     = true       // show full path
-	after        = 0          // show after lines
-	before       = 0          // show before lines
-	separator    = ":"        // column separator
-	useGitIgnore bool         // respect .gitignore files
-	skipHidden   bool         // skip hidden files/directories
+	opts     grep.Options  // search options
+	encs     string        // encodings
+	infile   string        // input filename
+	utf8out  bool          // output utf-8 strings
+	color    string        // color operation
+	format   string        // output format
+	timeout  time.Duration // timeout of the search
+	allowTty bool          // allow to search tty
+	maxCount = -1          // -m NUM, or -1 if not given
 )
 
-type ignoreChecker struct {
-	dir string
-	gi  *ignore.GitIgnore
-}
-
-type gitIgnoreManager struct {
-	matchers sync.Map // dir path -> *ignore.GitIgnore (or nil)
-	gitRoots sync.Map // dir path -> bool
-	chains   sync.Map // dir path -> []ignoreChecker (cached ancestor chain)
-}
-
-var replbytes = []byte{0xef, 0xbf, 0xbd} // bytes representation of the replacement rune '\uFFFD'
-
-func printLineZero(s string) {
-	printStr(s + "\x00")
-}
-
-func printLineNorm(s string) {
-	printStr(s + "\n")
-}
-
-var printLine = printLineNorm
-
-func printStr(s string) {
-	printBytes([]byte(s))
-}
+type utf8Writer struct{}
 
-func printBytesUtf8(b []byte) {
-	syscall.Write(syscall.Stdout, b)
-}
-
-func printBytesOutc(b []byte) {
-	oc.Write(b)
-}
-
-func printBytesNorm(b []byte) {
-	stdout.Write(b)
-}
-
-var printBytes = printBytesNorm
-
-func matchedFile(f string, a *GrepArg) {
-	if a.output != "" {
-		f = a.output
-	} else if !fullpath {
-		if fe, err := filepath.Rel(cwd, f); err == nil {
-			f = fe
-		}
-	}
-	a.writeLine(f)
-}
-
-func matchedLine(f string, l, c int, m string, a *GrepArg) {
-	if f != "" && a.output != "" {
-		f = a.output
-	}
-	lc := separator
-	if l < 0 {
-		lc = "-"
-		l = -l
-	}
-	ls := fmt.Sprint(l)
-	if column && c != -1 {
-		ls += ":" + fmt.Sprint(c+1)
-	}
-	if !a.atty {
-		if f != "" {
-			if a.output == "" && !fullpath {
-				if fe, err := filepath.Rel(cwd, f); err == nil {
-					f = fe
-				}
-			}
-			if zeroFile {
-				a.writeStr(f + separator + ls + "\x00")
-			} else {
-				a.writeStr(f + separator + ls + lc)
-			}
-		}
-		a.writeLine(m)
-		return
-	}
-	if f != "" {
-		if a.output == "" && !fullpath {
-			if fe, err := filepath.Rel(cwd, f); err == nil {
-				f = fe
-			}
-		}
-		if zeroFile {
-			a.writeStr(cMAGENTA + f + cRESET + "\x00" + cGREEN + ls + cCYAN + separator + cRESET)
-		} else {
-			a.writeStr(cMAGENTA + f + cRESET + separator + cGREEN + ls + cCYAN + separator + cRESET)
-		}
-	}
-	if re, ok := a.pattern.(*regexp.Regexp); ok {
-		ill := re.FindAllStringIndex(m, -1)
-		if len(ill) == 0 {
-			a.writeLine(m)
-			return
-		}
-		for i, il := range ill {
-			if i > 0 {
-				a.writeStr(m[ill[i-1][1]:il[0]] + cRED + m[il[0]:il[1]] + cRESET)
-			} else {
-				a.writeStr(m[0:il[0]] + cRED + m[il[0]:il[1]] + cRESET)
-			}
-		}
-		a.writeLine(m[ill[len(ill)-1][1]:])
-	} else if s, ok := a.pattern.(string); ok {
-		l := len(s)
-		for {
-			i := strings.Index(m, s)
-			if i < 0 {
-				a.writeLine(m)
-				break
-			}
-			a.writeStr(m[0:i] + cRED + m[i:i+l] + cRESET)
-			m = m[i+l:]
-		}
-	}
-}
-
-func matchedLineBytes(f string, l, c int, m []byte, a *GrepArg) {
-	if a.atty {
-		matchedLine(f, l, c, string(m), a)
-		return
-	}
-	if f != "" && a.output != "" {
-		f = a.output
-	}
-	lc := separator
-	if l < 0 {
-		lc = "-"
-		l = -l
-	}
-	if f != "" {
-		if a.output == "" && !fullpath {
-			if fe, err := filepath.Rel(cwd, f); err == nil {
-				f = fe
-			}
-		}
-		a.writeStr(f)
-		a.writeStr(separator)
-		a.writeInt(l)
-		if column && c != -1 {
-			a.writeByte(':')
-			a.writeInt(c + 1)
-		}
-		if zeroFile {
-			a.writeByte(0)
-		} else {
-			a.writeStr(lc)
-		}
-	}
-	a.writeBytes(m)
-	if zeroData {
-		a.writeByte(0)
-	} else {
-		a.writeByte('\n')
-	}
+func (utf8Writer) Write(b []byte) (int, error) {
+	return syscall.Write(syscall.Stdout, b)
 }
 
 func errorLine(s string) {
 	os.Stderr.WriteString(s + "\n")
 }
 
-func maybeBinary(b []byte) bool {
-	// Check only the first 8KB, like ripgrep.
-	l := len(b)
-	if l > 8192 {
-		l = 8192
-	}
-	for i := 0; i < l; i++ {
-		if b[i] == 0x00 || (0 < b[i] && b[i] < 0x9) {
-			return true
-		}
-	}
-	return false
-}
-
-func (g *gitIgnoreManager) loadGitIgnore(dir string) *ignore.GitIgnore {
-	if v, ok := g.matchers.Load(dir); ok {
-		gi, _ := v.(*ignore.GitIgnore)
-		return gi
-	}
-	gi, err := ignore.CompileIgnoreFile(dir + "/.gitignore")
-	if err != nil {
-		g.matchers.Store(dir, (*ignore.GitIgnore)(nil))
-		return nil
-	}
-	g.matchers.Store(dir, gi)
-	return gi
-}
-
-func (g *gitIgnoreManager) isGitRoot(dir string) bool {
-	if v, ok := g.gitRoots.Load(dir); ok {
-		return v.(bool)
-	}
-	_, err := os.Stat(dir + "/.git")
-	isRoot := err == nil
-	g.gitRoots.Store(dir, isRoot)
-	return isRoot
-}
-
-func (g *gitIgnoreManager) getChain(dir string) []ignoreChecker {
-	if v, ok := g.chains.Load(dir); ok {
-		return v.([]ignoreChecker)
-	}
-	var dirs []string
-	for d := dir; ; d = filepath.Dir(d) {
-		dirs = append(dirs, d)
-		if g.isGitRoot(d) || d == filepath.Dir(d) {
-			break
-		}
-	}
-	var chain []ignoreChecker
-	for i := len(dirs) - 1; i >= 0; i-- {
-		if gi := g.loadGitIgnore(dirs[i]); gi != nil {
-			chain = append(chain, ignoreChecker{dir: dirs[i], gi: gi})
-		}
-	}
-	g.chains.Store(dir, chain)
-	return chain
-}
-
-func (g *gitIgnoreManager) isIgnored(absPath string, isDir bool) bool {
-	dir := filepath.Dir(absPath)
-	chain := g.getChain(dir)
-	for _, c := range chain {
-		rel := absPath[len(c.dir)+1:]
-		if isDir {
-			rel += "/"
-		}
-		if c.gi.MatchesPath(rel) {
-			return true
-		}
-	}
-	return false
-}
-
-func matchFixed(data, needle []byte) []int {
-	idx := bytes.Index(data, needle)
-	if idx < 0 {
-		return nil
-	}
-	return []int{idx, idx + len(needle)}
-}
-
-func lowerASCIIByte(b byte) byte {
-	if 'A' <= b && b <= 'Z' {
-		return b + ('a' - 'A')
-	}
-	return b
-}
-
-func lowerASCIIBytes(dst, src []byte) {
-	for i, b := range src {
-		dst[i] = lowerASCIIByte(b)
-	}
-}
-
-func indexFoldASCII(data, needle []byte) int {
-	nl := len(needle)
-	dl := len(data)
-	if nl == 0 {
-		return 0
-	}
-	if nl > dl {
-		return -1
-	}
-	last := dl - nl
-	first := needle[0]
-	for i := 0; i <= last; i++ {
-		if lowerASCIIByte(data[i]) != first {
-			continue
-		}
-		j := 1
-		for ; j < nl; j++ {
-			if lowerASCIIByte(data[i+j]) != needle[j] {
-				break
-			}
-		}
-		if j == nl {
-			return i
-		}
-	}
-	return -1
-}
-
-func doGrepFixedUTF8(path string, fb []byte, arg *GrepArg, needle []byte) bool {
-	if ignorebinary && maybeBinary(fb) {
-		return false
-	}
-
-	if len(fb) >= 3 && fb[0] == 0xef && fb[1] == 0xbb && fb[2] == 0xbf {
-		arg.bom = fb[:3]
-		fb = fb[3:]
-	} else {
-		arg.bom = nil
-	}
-
-	if list && !invert {
-		if bytes.Index(fb, needle) >= 0 {
-			matchedFile(path, arg)
-			return true
-		}
-		return false
-	}
-
-	var matched bool
-	lineNo := 0
-	start := 0
-	size := len(fb)
-
-	for start <= size {
-		end := size
-		if off := bytes.IndexByte(fb[start:], '\n'); off >= 0 {
-			end = start + off
-		}
-		lineNo++
-		line := fb[start:end]
-		if l := len(line); l > 0 && line[l-1] == '\r' {
-			line = line[:l-1]
-		}
-
-		var indexes [][]int
-		if only {
-			offset := 0
-			for offset <= len(line)-len(needle) {
-				idx := bytes.Index(line[offset:], needle)
-				if idx < 0 {
-					break
-				}
-				idx += offset
-				indexes = append(indexes, []int{idx, idx + len(needle)})
-				offset = idx + 1
-			}
-		} else if idx := matchFixed(line, needle); idx != nil {
-			indexes = append(indexes, idx)
-		}
-
-		hasMatch := len(indexes) > 0
-		if hasMatch == invert {
-			if end == size {
-				break
-			}
-			start = end + 1
-			continue
-		}
-		if list {
-			matchedFile(path, arg)
-			return true
-		}
-
-		if only {
-			for _, mm := range indexes {
-				atomic.AddInt64(&countMatch, 1)
-				if count {
-					matched = true
-					continue
-				}
-				part := line[mm[0]:mm[1]]
-				if arg.atty && maybeBinary(part) {
-					errorLine(fmt.Sprintf("matched binary file: %s", path))
-					return true
-				}
-				if number {
-					matchedLineBytes(path, lineNo, mm[0], part, arg)
-				} else {
-					matchedLineBytes("", 0, mm[0], part, arg)
-				}
-				matched = true
-			}
-		} else {
-			atomic.AddInt64(&countMatch, 1)
-			if count {
-				matched = true
-			} else {
-				matchedIndex := -1
-				if hasMatch {
-					matchedIndex = indexes[0][0]
-				}
-				if arg.atty && maybeBinary(line) {
-					errorLine(fmt.Sprintf("matched binary file: %s", path))
-					return true
-				}
-				if arg.single && !number {
-					matchedLineBytes("", -1, matchedIndex, line, arg)
-				} else {
-					matchedLineBytes(path, lineNo, matchedIndex, line, arg)
-				}
-				matched = true
-			}
-		}
-
-		if end == size {
-			break
-		}
-		start = end + 1
-	}
-	return matched
-}
-
-func doGrepFixedUTF8FoldASCII(path string, fb []byte, arg *GrepArg, needle []byte) bool {
-	if ignorebinary && maybeBinary(fb) {
-		return false
-	}
-
-	if len(fb) >= 3 && fb[0] == 0xef && fb[1] == 0xbb && fb[2] == 0xbf {
-		arg.bom = fb[:3]
-		fb = fb[3:]
-	} else {
-		arg.bom = nil
-	}
-
-	if list && !invert {
-		if indexFoldASCII(fb, needle) >= 0 {
-			matchedFile(path, arg)
-			return true
-		}
-		return false
-	}
-
-	var matched bool
-	lineNo := 0
-	start := 0
-	size := len(fb)
-
-	for start <= size {
-		end := size
-		if off := bytes.IndexByte(fb[start:], '\n'); off >= 0 {
-			end = start + off
-		}
-		lineNo++
-		line := fb[start:end]
-		if l := len(line); l > 0 && line[l-1] == '\r' {
-			line = line[:l-1]
-		}
-
-		var indexes [][]int
-		if only {
-			offset := 0
-			for offset <= len(line)-len(needle) {
-				idx := indexFoldASCII(line[offset:], needle)
-				if idx < 0 {
-					break
-				}
-				idx += offset
-				indexes = append(indexes, []int{idx, idx + len(needle)})
-				offset = idx + 1
-			}
-		} else if idx := indexFoldASCII(line, needle); idx >= 0 {
-			indexes = append(indexes, []int{idx, idx + len(needle)})
-		}
-
-		hasMatch := len(indexes) > 0
-		if hasMatch == invert {
-			if end == size {
-				break
-			}
-			start = end + 1
-			continue
-		}
-		if list {
-			matchedFile(path, arg)
-			return true
-		}
-
-		if only {
-			for _, mm := range indexes {
-				atomic.AddInt64(&countMatch, 1)
-				if count {
-					matched = true
-					continue
-				}
-				part := line[mm[0]:mm[1]]
-				if arg.atty && maybeBinary(part) {
-					errorLine(fmt.Sprintf("matched binary file: %s", path))
-					return true
-				}
-				if number {
-					matchedLineBytes(path, lineNo, mm[0], part, arg)
-				} else {
-					matchedLineBytes("", 0, mm[0], part, arg)
-				}
-				matched = true
-			}
-		} else {
-			atomic.AddInt64(&countMatch, 1)
-			if count {
-				matched = true
-			} else {
-				matchedIndex := -1
-				if hasMatch {
-					matchedIndex = indexes[0][0]
-				}
-				if arg.atty && maybeBinary(line) {
-					errorLine(fmt.Sprintf("matched binary file: %s", path))
-					return true
-				}
-				if arg.single && !number {
-					matchedLineBytes("", -1, matchedIndex, line, arg)
-				} else {
-					matchedLineBytes(path, lineNo, matchedIndex, line, arg)
-				}
-				matched = true
-			}
-		}
-
-		if end == size {
-			break
-		}
-		start = end + 1
-	}
-	return matched
-}
-
-func doGrep(path string, fb []byte, arg *GrepArg) bool {
-	encs := encodings
-
-	if ignorebinary {
-		if maybeBinary(fb) {
-			return false
-		}
-	}
-
-	if len(fb) > 2 {
-		if fb[0] == 0xfe && fb[1] == 0xff {
-			arg.bom = fb[0:2]
-			fb = fb[2:]
-		} else if fb[0] == 0xff && fb[1] == 0xfe {
-			arg.bom = fb[0:2]
-			fb = fb[2:]
-		} else if len(fb) > 3 && fb[0] == 0xef && fb[1] == 0xbb && fb[2] == 0xbf {
-			arg.bom = fb[0:3]
-			fb = fb[3:]
-		}
-		if len(arg.bom) > 0 {
-			if arg.bom[0] == 0xfe && arg.bom[1] == 0xff {
-				encs = []string{"utf-16be"}
-			} else if arg.bom[0] == 0xff && arg.bom[1] == 0xfe {
-				encs = []string{"utf-16le"}
-			} else if len(arg.bom) == 3 {
-				encs = []string{""}
-			}
-		}
-	}
-
-	re, _ := arg.pattern.(*regexp.Regexp)
-	rs, _ := arg.pattern.(string)
-
-	if re == nil && rs != "" && len(encs) == 1 && encs[0] == "utf-8" {
-		if !ignorecase {
-			return doGrepFixedUTF8(path, fb, arg, arg.needle)
-		}
-		if arg.ascii {
-			return doGrepFixedUTF8FoldASCII(path, fb, arg, arg.folded)
-		}
-	}
-
-	var okay bool
-	var f []byte
-	var istext bool
-	for e, enc := range encs {
-		if e > 0 && istext && arg.ascii && !strings.HasPrefix(enc, "utf-16") {
-			continue
-		}
-		if verbose {
-			println("trying("+enc+"):", path)
-		}
-		if len(arg.bom) == 2 && enc != "utf-16be" && enc != "utf-16le" {
-			continue
-		}
-
-		did := false
-		var t []byte
-		var n, l, size, next, prev int
-
-		f = fb
-		if enc != "" {
-			if len(arg.bom) > 0 || !maybeBinary(fb) {
-				ee, _ := charset.Lookup(enc)
-				if ee == nil {
-					continue
-				}
-				var buf bytes.Buffer
-				ic := transform.NewWriter(&buf, ee.NewDecoder())
-				_, err := ic.Write(fb)
-				if err != nil {
-					if verbose {
-						println(err.Error())
-					}
-					next = -1
-					continue
-				}
-				lf := false
-				if len(arg.bom) == 2 && len(fb)%2 != 0 {
-					ic.Write([]byte{0})
-					lf = true
-				}
-				err = ic.Close()
-				if err != nil {
-					if verbose {
-						println(err.Error())
-					}
-					next = -1
-					continue
-				}
-				f = buf.Bytes()
-				if lf {
-					f = f[:len(f)-1]
-				}
-				if bytes.Index(f, replbytes) > -1 {
-					next = -1
-					continue
-				}
-			}
-			istext = true
-		}
-		size = len(f)
-		if size == 0 {
-			continue
-		}
-
-		for next != -1 {
-			for {
-				if next >= size {
-					next = -1
-					break
-				}
-				if f[next] == '\n' {
-					break
-				}
-				next++
-			}
-			n++
-			if next == -1 {
-				t = f[prev:]
-			} else {
-				t = f[prev:next]
-				prev = next + 1
-				next++
-			}
-
-			l = len(t)
-			if l > 0 && t[l-1] == '\r' {
-				t = t[:l-1]
-				l--
-			}
-
-			var match bool
-			var matches [][]int
-			if only {
-				ts := string(t)
-				if re != nil {
-					matches = re.FindAllStringIndex(ts, -1)
-				} else {
-					if ignorecase {
-						ts = strings.ToLower(ts)
-					}
-					ti := 0
-					tl := len(ts)
-					rl := len(rs)
-					matches = make([][]int, 0, 10)
-					for ti < tl {
-						idx := strings.Index(ts[ti:], rs)
-						if idx == -1 {
-							break
-						}
-						matches = append(matches, []int{ti + idx, ti + idx + rl})
-						ti += idx + 1
-					}
-				}
-				match = len(matches) > 0
-				// skip if not match without invert, or match with invert.
-				if match == invert {
-					continue
-				}
-				if verbose {
-					println("found("+enc+"):", path)
-				}
-				if list {
-					matchedFile(path, arg)
-					did = true
-					break
-				}
-				for _, mm := range matches {
-					atomic.AddInt64(&countMatch, 1)
-					if count {
-						continue
-					}
-					m := []byte(ts)[mm[0]:mm[1]]
-					if arg.atty && maybeBinary(m) {
-						errorLine(fmt.Sprintf("matched binary file: %s", path))
-						did = true
-						break
-					} else {
-						if number {
-							if utf8.Valid(m) {
-								matchedLine(path, n, mm[0], string(m), arg)
-							} else {
-								errorLine(fmt.Sprintf("matched binary file: %s", path))
-								did = true
-								break
-							}
-						} else {
-							if utf8.Valid(m) {
-								matchedLine("", 0, mm[0], string(m), arg)
-							} else {
-								errorLine(fmt.Sprintf("matched binary file: %s", path))
-								did = true
-								break
-							}
-						}
-					}
-				}
-			} else {
-				if re != nil {
-					matches = re.FindAllIndex(t, 1)
-				} else {
-					if ignorecase {
-						ti := strings.Index(strings.ToLower(string(t)), strings.ToLower(rs))
-						if ti != -1 {
-							matches = append(matches, []int{ti, ti + len(rs)})
-						}
-					} else {
-						ti := strings.Index(string(t), rs)
-						if ti > -1 {
-							matches = append(matches, []int{ti, ti + len(rs)})
-						}
-					}
-				}
-				match = len(matches) > 0
-				// skip if not match without invert, or match with invert.
-				if match == invert {
-					continue
-				}
-				if verbose {
-					println("found("+enc+"):", path)
-				}
-				if list {
-					matchedFile(path, arg)
-					did = true
-					break
-				}
-				atomic.AddInt64(&countMatch, 1)
-				if count {
-					did = true
-					continue
-				}
-				matchedIndex := -1
-				if match {
-					matchedIndex = matches[0][0]
-				}
-				if arg.single && !number {
-					if utf8.Valid(t) {
-						matchedLine("", -1, matchedIndex, string(t), arg)
-					} else {
-						errorLine(fmt.Sprintf("matched binary file: %s", path))
-						did = true
-						break
-					}
-				} else {
-					if arg.atty && maybeBinary(t) {
-						errorLine(fmt.Sprintf("matched binary file: %s", path))
-						did = true
-						break
-					} else if utf8.Valid(t) {
-						if after <= 0 && before <= 0 {
-							matchedLine(path, n, matchedIndex, string(t), arg)
-						} else {
-							if atomic.LoadInt64(&countMatch) > 1 {
-								arg.buf.WriteString("---\n")
-							}
-							bprev, bnext := next-l-2, next-l-2
-							lines := make([]string, 0, 10)
-							for i := 0; i < before && bprev > 0; i++ {
-								for {
-									if bprev == 0 || f[bprev-1] == '\n' {
-										lines = append(lines, string(f[bprev:bnext]))
-										bnext = bprev - 1
-										bprev--
-										break
-									}
-									bprev--
-								}
-							}
-							for i := len(lines); i > 0; i-- {
-								matchedLine(path, i-n, matchedIndex, lines[i-1], arg)
-							}
-							matchedLine(path, n, matchedIndex, string(t), arg)
-							lines = make([]string, 0, 10)
-							aprev, anext := next, next
-							for i := 0; i < after && anext >= 0 && anext < size; i++ {
-								for {
-									if anext == size || f[anext] == '\n' {
-										lines = append(lines, string(f[aprev:anext]))
-										aprev = anext + 1
-										anext++
-										break
-									}
-									anext++
-								}
-							}
-							for i := 0; i < len(lines); i++ {
-								matchedLine(path, -n-i-1, matchedIndex, lines[i], arg)
-							}
-						}
-					} else {
-						errorLine(fmt.Sprintf("matched binary file: %s", path))
-						did = true
-						break
-					}
-				}
-			}
-			did = true
-		}
-		if did {
-			okay = true
-		}
-		if did || len(fb) == 0 {
-			break
-		}
-	}
-	return okay
-}
-
-// Grep do grep.
-func flushArg(arg *GrepArg) {
-	if arg.buf.Len() > 0 {
-		printBytes(arg.buf.Bytes())
-		arg.buf.Reset()
-	}
-}
-
-// Grep do grep.
-func Grep(arg *GrepArg) bool {
-	n := false
-	if in, ok := arg.input.(io.Reader); ok {
-		stdin := bufio.NewReader(in)
-		for {
-			f, _, err := stdin.ReadLine()
-			if doGrep("stdin", f, arg) {
-				n = true
-			}
-			flushArg(arg)
-			if err != nil {
-				break
-			}
-		}
-		return n
-	}
-
-	path, _ := arg.input.(string)
-	if arg.size > 65536*4 {
-		mf, err := mmap.Open(path)
-		if err != nil {
-			errorLine(err.Error() + ": " + path)
-			return false
-		}
-		f := mf.Data()
-		r := doGrep(path, f, arg)
-		flushArg(arg)
-		mf.Close()
-		return r
-	}
-	f, err := os.ReadFile(path)
-	if err != nil {
-		errorLine(err.Error() + ": " + path)
-		return false
-	}
-	r := doGrep(path, f, arg)
-	flushArg(arg)
-	return r
-}
-
-func goGrep(ch chan *GrepArg, done chan bool, mu *sync.Mutex) {
-	n := 0
-	for {
-		arg := <-ch
-		if arg == nil {
-			break
-		}
-		path, ok := arg.input.(string)
-		if !ok {
-			mu.Lock()
-			if Grep(arg) {
-				n++
-			}
-			mu.Unlock()
-			continue
-		}
-		// Read file outside lock for parallel I/O
-		var data []byte
-		var mf *mmap.Memfile
-		var err error
-		if arg.size > 65536*4 {
-			mf, err = mmap.Open(path)
-			if err != nil {
-				mu.Lock()
-				errorLine(err.Error() + ": " + path)
-				mu.Unlock()
-				continue
-			}
-			data = mf.Data()
-		} else {
-			data, err = os.ReadFile(path)
-			if err != nil {
-				mu.Lock()
-				errorLine(err.Error() + ": " + path)
-				mu.Unlock()
-				continue
-			}
-		}
-		// Grep outside lock for parallel matching
-		matched := doGrep(path, data, arg)
-		// Flush buffered output under lock
-		mu.Lock()
-		flushArg(arg)
-		mu.Unlock()
-		if mf != nil {
-			mf.Close()
-		}
-		if matched {
-			n++
-		}
-	}
-	done <- n > 0
-}
-
 func showVersion() {
 	fmt.Fprintf(os.Stdout, "%s\n", version)
 	os.Exit(0)
@@ -1123,12 +68,42 @@ Regexp selection and interpretation:
   -P               : PATTERN is a Perl regular expression (ERE)
   -f FILE          : obtain PATTERN from FILE
   -i               : ignore case
+  --fold-width     : match full-width and half-width forms alike, like
+                     ＡＢＣ and ABC, or ｶﾀｶﾅ and カタカナ
+  --fold-kana      : match hiragana and katakana alike, like けんさく and
+                     ケンサク
+  --normalize=FORM : normalize the pattern and the lines to the Unicode
+                     form nfc or nfkc before matching
+  --fold-kanji-variants
+                   : match the variants of kanji alike, like 髙橋 and 高橋,
+                     or 齋藤, 斎藤 and 齊藤, from a bundled table
+  --kanji-variants=FILE
+                   : add the groups of variants in FILE to the table, one
+                     group of kanji like 斎齋齊 each, separated by spaces
+  --migemo         : PATTERN is romaji like kensaku, matching 検索, けんさく,
+                     ケンサク and kensaku with the dictionary of C/Migemo
+  --migemo-dict=PATH
+                   : read the dictionary of C/Migemo from PATH
   -z, --null-data  : a data line ends in 0 byte, not newline
-  --enc=ENCODINGS  : encodings of input files: comma separated
+  --enc=ENCODINGS  : encodings of input files: comma separated, may include
+                     presets like @cjk
+  --enc-for=GLOB=ENC
+                   : search the files matching GLOB in the encoding ENC
+                     without trying others; may be given more than once
+  --editorconfig   : search files in the charset given by .editorconfig
+  --mixed-encoding : detect the encoding of each line in files that mix
+                     encodings, and print it before each line
+  --inventory [PATH]...
+                   : print the encoding, confidence, BOM, line endings and
+                     whether it is binary or lossy for each file under PATH
+                     without searching, then a summary by encoding
   --tty            : allow to search stdin even it is connected to a tty
 
 Miscellaneous:
   -S               : verbose messages
+  --timeout=DURATION
+                   : stop searching after DURATION (e.g. 30s, 5m) and exit
+                     with status 3
   -V, --version    : print version information and exit
 
 Output control:
@@ -1141,28 +116,51 @@ Output control:
                      (specifying empty string won't exclude any files)
   --no-color       : do not print colors
   --color[=WHEN]   : always/never/auto
-  -c               : count matches
+  --format=FORMAT  : output format: %s
+                     (default: color on a terminal, plain otherwise)
+  --json           : print the results as JSON Lines (same as --format=json)
+  --vimgrep        : print file:line:column:text for each match, counting the
+                     column in characters (same as --format=vimgrep), not
+                     with --raw-output
+  --show-encoding  : print the encoding of the file and whether it has a BOM,
+                     like [sjis] or [utf-8,bom], before each line
+  --raw-output     : print the lines as they are in the file, not converted
+                     to UTF-8 or $JVGREP_OUTPUT_ENCODING
+  -c               : print count of matching lines for each file
+  --count-total    : print only the total count of matching lines
+  --count-matches  : count each match instead of matching lines
   -C               : show column
   -r               : print relative path
   -I               : ignore binary files
   -l               : print only names of FILEs containing matches
+  -m, --max-count=NUM
+                   : stop reading a file after NUM selected lines
+  --limit=NUM      : stop searching after NUM selected lines in total
   -n               : print line number with output lines
   -o               : show only the part of a line matching PATTERN
   -v               : select non-matching lines
   -Z, --null       : print 0 byte after FILE name
   --separator=CHAR : set column separator to CHAR (default: ":")
+  --sort=ORDER     : order of files: none/path (default: none)
+                     path prints the results in the order of the paths while
+                     still searching in parallel
 
 Context control:
   -B NUM           : print NUM lines of leading context
   -A NUM           : print NUM lines of trailing context
 
-`, version, excludeDefaults)
+`, version, grep.DefaultExclude, strings.Join(grep.Formats(), "/"))
 		fmt.Println("Supported Encodings:")
-		for _, enc := range encodings {
+		for _, enc := range grep.DefaultEncodings {
 			if enc != "" {
 				fmt.Println("    " + enc)
 			}
 		}
+		fmt.Println("    gb18030, big5, euc-kr, windows-1252, utf-32le, utf-32be, ...")
+		fmt.Println("Encoding Presets:")
+		for _, name := range grep.Presets() {
+			fmt.Println("    " + name + ": " + strings.Join(grep.EncodingPresets[name], ","))
+		}
 	}
 	os.Exit(2)
 }
@@ -1177,53 +175,61 @@ func parseOptions() []string {
 			switch argv[n][1] {
 			case 'A':
 				if len(argv[n]) > 2 {
-					after, _ = strconv.Atoi(argv[n][2:])
+					opts.After, _ = strconv.Atoi(argv[n][2:])
 					continue
 				} else if n < argc-1 {
-					after, _ = strconv.Atoi(argv[n+1])
+					opts.After, _ = strconv.Atoi(argv[n+1])
 					n++
 					continue
 				}
 			case 'B':
 				if len(argv[n]) > 2 {
-					before, _ = strconv.Atoi(argv[n][2:])
+					opts.Before, _ = strconv.Atoi(argv[n][2:])
+					continue
+				} else if n < argc-1 {
+					opts.Before, _ = strconv.Atoi(argv[n+1])
+					n++
+					continue
+				}
+			case 'm':
+				if len(argv[n]) > 2 {
+					maxCount = parseCount(argv[n][2:], 0)
 					continue
 				} else if n < argc-1 {
-					before, _ = strconv.Atoi(argv[n+1])
+					maxCount = parseCount(argv[n+1], 0)
 					n++
 					continue
 				}
 			case '8':
 				utf8out = true
-				printBytes = printBytesUtf8
 			case 'F':
-				fixed = true
+				opts.Fixed = true
 			case 'R':
-				recursive = true
+				opts.Recursive = true
 			case 'S':
-				verbose = true
+				opts.Verbose = true
 			case 'c':
-				count = true
+				opts.Count = true
 			case 'C':
-				column = true
+				opts.Column = true
 			case 'r':
-				fullpath = false
+				opts.Relative = true
 			case 'i':
-				ignorecase = true
+				opts.IgnoreCase = true
 			case 'I':
-				ignorebinary = true
+				opts.IgnoreBinary = true
 			case 'l':
-				list = true
+				opts.List = true
 			case 'n':
-				number = true
+				opts.Number = true
 			case 'P':
-				perl = true
+				opts.Perl = true
 			case 'G':
-				basic = true
+				opts.Basic = true
 			case 'v':
-				invert = true
+				opts.Invert = true
 			case 'o':
-				only = true
+				opts.Only = true
 			case 'f':
 				if n < argc-1 {
 					infile = argv[n+1]
@@ -1231,10 +237,9 @@ func parseOptions() []string {
 					continue
 				}
 			case 'z':
-				zeroData = true
-				printLine = printLineZero
+				opts.ZeroData = true
 			case 'Z':
-				zeroFile = true
+				opts.ZeroFile = true
 			case 'V':
 				showVersion()
 			default:
@@ -1252,10 +257,48 @@ func parseOptions() []string {
 			case name == "enc" && n < argc-1:
 				encs = argv[n+1]
 				n++
+			case strings.HasPrefix(name, "enc-for="):
+				opts.EncodingRules = append(opts.EncodingRules, name[8:])
+			case name == "enc-for" && n < argc-1:
+				opts.EncodingRules = append(opts.EncodingRules, argv[n+1])
+				n++
+			case name == "fold-width":
+				opts.FoldWidth = true
+			case name == "fold-kana":
+				opts.FoldKana = true
+			case strings.HasPrefix(name, "normalize="):
+				opts.Normalize = name[10:]
+			case name == "normalize" && n < argc-1:
+				opts.Normalize = argv[n+1]
+				n++
+			case name == "fold-kanji-variants":
+				opts.FoldKanjiVariants = true
+			case strings.HasPrefix(name, "kanji-variants="):
+				opts.FoldKanjiVariants = true
+				opts.KanjiVariants = name[15:]
+			case name == "kanji-variants" && n < argc-1:
+				opts.FoldKanjiVariants = true
+				opts.KanjiVariants = argv[n+1]
+				n++
+			case name == "migemo":
+				opts.Migemo = true
+			case strings.HasPrefix(name, "migemo-dict="):
+				opts.Migemo = true
+				opts.MigemoDict = name[12:]
+			case name == "migemo-dict" && n < argc-1:
+				opts.Migemo = true
+				opts.MigemoDict = argv[n+1]
+				n++
+			case name == "editorconfig":
+				opts.EditorConfig = true
+			case name == "mixed-encoding":
+				opts.MixedEncoding = true
+			case name == "inventory":
+				opts.Inventory = true
 			case strings.HasPrefix(name, "exclude="):
-				exclude = name[8:]
+				opts.Exclude = name[8:]
 			case name == "exclude" && n < argc-1:
-				exclude = argv[n+1]
+				opts.Exclude = argv[n+1]
 				n++
 			case strings.HasPrefix(name, "color="):
 				color = name[6:]
@@ -1264,19 +307,56 @@ func parseOptions() []string {
 			case name == "color" && n < argc-1:
 				color = argv[n+1]
 				n++
+			case strings.HasPrefix(name, "format="):
+				format = name[7:]
+			case name == "format" && n < argc-1:
+				format = argv[n+1]
+				n++
+			case name == "show-encoding":
+				opts.ShowEncoding = true
+			case name == "raw-output":
+				opts.RawOutput = true
+			case name == "json":
+				format = "json"
+			case name == "vimgrep":
+				format = "vimgrep"
+			case strings.HasPrefix(name, "timeout="):
+				timeout = parseDuration(name[8:])
+			case name == "timeout" && n < argc-1:
+				timeout = parseDuration(argv[n+1])
+				n++
+			case strings.HasPrefix(name, "sort="):
+				opts.Sort = name[5:]
+			case name == "sort" && n < argc-1:
+				opts.Sort = argv[n+1]
+				n++
+			case strings.HasPrefix(name, "max-count="):
+				maxCount = parseCount(name[10:], 0)
+			case name == "max-count" && n < argc-1:
+				maxCount = parseCount(argv[n+1], 0)
+				n++
+			case strings.HasPrefix(name, "limit="):
+				opts.Limit = parseCount(name[6:], 1)
+			case name == "limit" && n < argc-1:
+				opts.Limit = parseCount(argv[n+1], 1)
+				n++
 			case strings.HasPrefix(name, "separator="):
-				separator = name[10:]
+				opts.Separator = name[10:]
 			case name == "separator":
-				separator = argv[n+1]
+				opts.Separator = argv[n+1]
 				n++
 			case name == "null":
-				zeroFile = true
+				opts.ZeroFile = true
 			case name == "null-data":
-				zeroData = true
+				opts.ZeroData = true
+			case name == "count-total":
+				opts.CountTotal = true
+			case name == "count-matches":
+				opts.CountMatches = true
 			case name == "gitignore":
-				useGitIgnore = true
+				opts.GitIgnore = true
 			case name == "skip-hidden":
-				skipHidden = true
+				opts.SkipHidden = true
 			case name == "tty":
 				allowTty = true
 			case name == "version":
@@ -1293,40 +373,70 @@ func parseOptions() []string {
 	return args
 }
 
+// parseCount parses a count that must be min or more.
+func parseCount(s string, min int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min {
+		errorLine(fmt.Sprintf("invalid count: %s", s))
+		usage(true)
+	}
+	return n
+}
+
+func parseDuration(s string) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		errorLine(fmt.Sprintf("invalid duration: %s", s))
+		usage(true)
+	}
+	return d
+}
+
 func doMain() int {
 	args := parseOptions()
 
+	if opts.Inventory {
+		return doInventory(args)
+	}
 	if len(args) == 0 {
 		usage(true)
 	}
+	if maxCount == 0 {
+		// like grep, -m 0 reads nothing
+		return 1
+	}
+	if maxCount > 0 {
+		opts.MaxCount = maxCount
+	}
 
-	var err error
-	var pattern interface{}
 	if encs != "" {
-		encodings = strings.Split(encs, ",")
+		opts.Encodings = strings.Split(encs, ",")
 	} else {
 		encEnv := os.Getenv("JVGREP_ENCODINGS")
 		if encEnv != "" {
-			encodings = strings.Split(encEnv, ",")
+			opts.Encodings = strings.Split(encEnv, ",")
 		}
 	}
-	for i := 0; i < len(encodings); i++ {
-		encodings[i] = strings.ToLower(encodings[i])
+
+	var out io.Writer = stdout
+	if utf8out && !opts.RawOutput {
+		out = utf8Writer{}
 	}
 	outEnc := os.Getenv("JVGREP_OUTPUT_ENCODING")
-	if outEnc != "" {
+	if outEnc != "" && !opts.RawOutput {
 		ee, _ := charset.Lookup(outEnc)
 		if ee == nil {
 			errorLine(fmt.Sprintf("unknown encoding: %s", outEnc))
 			os.Exit(1)
 		}
-		oc = transform.NewWriter(stdout, ee.NewEncoder())
+		oc := transform.NewWriter(stdout, ee.NewEncoder())
+		defer oc.Close()
 		if !utf8out {
-			printBytes = printBytesOutc
+			out = oc
 		}
 	}
+	opts.Stdout = out
 
-	instr := ""
 	argindex := 0
 	if len(infile) > 0 {
 		b, err := os.ReadFile(infile)
@@ -1334,344 +444,76 @@ func doMain() int {
 			errorLine(err.Error())
 			os.Exit(1)
 		}
-		instr = strings.TrimSpace(string(b))
+		opts.Pattern = strings.TrimSpace(string(b))
 	} else {
-		instr = args[0]
+		opts.Pattern = args[0]
 		argindex = 1
 	}
 
-	ascii := false
-	if fixed {
-		pattern = instr
-		ascii = isASCII(instr)
-	} else if perl {
-		re, err := syntax.Parse(instr, syntax.Perl)
-		if err != nil {
-			errorLine(err.Error())
-			os.Exit(1)
-		}
-		rec, err := syntax.Compile(re)
-		if err != nil {
-			errorLine(err.Error())
-			os.Exit(1)
-		}
-		instr = rec.String()
-		if ignorecase {
-			instr = "(?i:" + instr + ")"
-		}
-		if isLiteralRegexp(instr) {
-			if verbose {
-				println("pattern treated as literal:", instr)
-			}
-			pattern = instr
-			ascii = isASCII(instr)
-		} else {
-			pattern, err = regexp.Compile(instr)
-			if err != nil {
-				errorLine(err.Error())
-				os.Exit(1)
-			}
-		}
-	} else {
-		if ignorecase {
-			instr = "(?i:" + instr + ")"
-		}
-		if isLiteralRegexp(instr) {
-			if verbose {
-				println("pattern treated as literal:", instr)
-			}
-			pattern = instr
-			ascii = isASCII(instr)
-		} else {
-			pattern, err = regexp.Compile(instr)
-			if err != nil {
-				errorLine(err.Error())
-				os.Exit(1)
-			}
-		}
-	}
-
-	if exclude == "" {
-		exclude = os.Getenv("JVGREP_EXCLUDE")
-	}
-	useDefaultExclude := exclude == ""
-	if useDefaultExclude {
-		exclude = excludeDefaults
-	}
-	var ere *regexp.Regexp
-	if !useDefaultExclude {
-		var err error
-		ere, err = regexp.Compile(exclude)
-		if err != nil {
-			errorLine(err.Error())
-			os.Exit(1)
-		}
+	if opts.Exclude == "" {
+		opts.Exclude = os.Getenv("JVGREP_EXCLUDE")
 	}
 
-	atty := false
 	if color == "" {
 		color = os.Getenv("JVGREP_COLOR")
 	}
 	if color == "" || color == "auto" {
-		atty = isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+		opts.Color = isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
 	} else if color == "always" {
-		atty = true
+		opts.Color = true
 	} else if color == "never" {
-		atty = false
+		opts.Color = false
 	} else {
 		usage(true)
 	}
 
-	if atty {
-		sc := make(chan os.Signal, 10)
-		signal.Notify(sc, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
-		go func() {
-			for range sc {
-				printStr(cRESET)
-				os.Exit(0)
-			}
-		}()
+	ctx, cancel, interrupted := searchContext(out)
+	defer cancel()
 
+	if opts.Color {
 		defer colorable.EnableColorsStdout(nil)()
 	}
 
-	if len(args) == 1 && argindex != 0 {
-		if (isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())) && !allowTty {
-			args = append(args, ".")
+	if format == "" {
+		if opts.Color {
+			format = "color"
 		} else {
-			if Grep(&GrepArg{
-				pattern: pattern,
-				input:   os.Stdin,
-				size:    -1,
-				single:  true,
-				atty:    atty,
-				ascii:   ascii,
-			}) {
-				return 1
-			}
+			format = "plain"
 		}
 	}
-
-	globmask := ""
-
-	var mu sync.Mutex
-	nworkers := runtime.GOMAXPROCS(0)
-	if nworkers < 1 {
-		nworkers = 1
+	p, err := grep.NewPrinter(format, out, &opts)
+	if err != nil {
+		errorLine(err.Error())
+		os.Exit(1)
 	}
-	ch := make(chan *GrepArg, nworkers*2)
-	done := make(chan bool, nworkers)
-	for i := 0; i < nworkers; i++ {
-		go goGrep(ch, done, &mu)
+	opts.Sink = p
+
+	s, err := grep.New(&opts)
+	if err != nil {
+		errorLine(err.Error())
+		os.Exit(1)
 	}
-	nargs := len(args[argindex:])
-	for _, arg := range args[argindex:] {
-		globmask = ""
-		root := ""
-		arg = strings.Trim(arg, `"`)
-		fi, err := os.Stat(arg)
-		if err == nil && fi.Mode().IsRegular() {
-			// existing files: emit grep directly.
-			ch <- newGrepArg(pattern, arg, fi.Size(), false, atty, ascii)
-			continue
-		} else if err == nil && fi.Mode().IsDir() {
-			// existing directories: no need to prepare extra for glob.
-		} else {
-			// otherwise: prepare glob with expand path.
-			root, globmask = prepareGlob(arg)
-		}
-		if root == "" {
-			path, _ := filepath.Abs(arg)
-			fi, err := os.Lstat(path)
-			if err != nil {
-				errorLine(fmt.Sprintf("jvgrep: %s: No such file or directory", arg))
-				os.Exit(1)
-			}
-			if !fi.IsDir() {
-				if fi.Size() == 0 {
-					continue
-				}
-				if verbose {
-					println("search:", path)
-				}
-				ch <- newGrepArg(pattern, path, fi.Size(), nargs == 1, atty, ascii)
-				continue
-			} else {
-				root = path
-				if fi.IsDir() {
-					globmask = "**/*"
-				} else {
-					globmask = "**/" + globmask
-				}
-			}
-		}
-		if globmask == "" {
-			globmask = "."
-		}
-		globmask = filepath.ToSlash(filepath.Clean(globmask))
-		if recursive {
-			if strings.Index(globmask, "/") > -1 {
-				globmask += "/"
-			} else {
-				globmask = "**/" + globmask
-			}
-		}
 
-		cc := []rune(globmask)
-		dirmask := ""
-		filemask := ""
-		for i := 0; i < len(cc); i++ {
-			if cc[i] == '*' {
-				if i < len(cc)-2 && cc[i+1] == '*' && cc[i+2] == '/' {
-					filemask += "(.*/)?"
-					dirmask = filemask
-					i += 2
-				} else {
-					filemask += "[^/]*"
-				}
-			} else {
-				c := cc[i]
-				if c == '/' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || 255 < c {
-					filemask += string(c)
-				} else {
-					filemask += fmt.Sprintf("[\\x%x]", c)
-				}
-				if c == '/' && dirmask == "" && strings.Index(filemask, "*") != -1 {
-					dirmask = filemask
-				}
+	if len(args) == 1 && argindex != 0 {
+		if (isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())) && !allowTty {
+			args = append(args, ".")
+		} else {
+			result, err := s.SearchReader(ctx, "stdin", os.Stdin)
+			p.Finish(s.Stats())
+			if code, ok := exitCode(err, interrupted); ok {
+				return code
 			}
-		}
-		if dirmask == "" {
-			dirmask = filemask
-		}
-		if len(filemask) > 0 && filemask[len(filemask)-1] == '/' {
-			if root == "" {
-				root = filemask
+			if result {
+				return 0
 			}
-			filemask += "[^/]*"
-		}
-		if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
-			dirmask = "(?i:" + dirmask + ")"
-			filemask = "(?i:" + filemask + ")"
-		}
-
-		root = filepath.Clean(root)
-		if root == "." {
-			dirmask = "./" + dirmask
-			filemask = "./" + filemask
-		}
-
-		dre := regexp.MustCompile("^" + dirmask)
-		fre := regexp.MustCompile("^" + filemask + "$")
-
-		if verbose {
-			println("dirmask:", dirmask)
-			println("filemask:", filemask)
-			println("root:", root)
-		}
-
-		absRoot, _ := filepath.Abs(root)
-		absRoot = filepath.ToSlash(absRoot)
-		var gim *gitIgnoreManager
-		if useGitIgnore {
-			gim = &gitIgnoreManager{}
+			return 1
 		}
-
-		isAbsRoot := filepath.IsAbs(root)
-		walker.Walk(root, func(path string, mode os.FileInfo) error {
-			path = filepath.ToSlash(path)
-
-			base := path
-			if i := strings.LastIndexByte(path, '/'); i >= 0 {
-				base = path[i+1:]
-			}
-
-			isDir := mode.IsDir()
-
-			if skipHidden && len(base) > 1 && base[0] == '.' {
-				if isDir {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-
-			if gim != nil {
-				if isDir && base == ".git" {
-					if i := strings.LastIndexByte(path, '/'); i >= 0 {
-						var parentAbs string
-						if isAbsRoot {
-							parentAbs = path[:i]
-						} else {
-							parentAbs = absRoot + "/" + path[:i]
-						}
-						gim.gitRoots.Store(parentAbs, true)
-					}
-					return filepath.SkipDir
-				}
-
-				var absPath string
-				if isAbsRoot {
-					absPath = path
-				} else {
-					absPath = absRoot + "/" + path
-				}
-				if gim.isIgnored(absPath, isDir) {
-					if isDir {
-						return filepath.SkipDir
-					}
-					return nil
-				}
-			}
-
-			if useDefaultExclude {
-				if isDir {
-					if excludeDirs[base] {
-						return filepath.SkipDir
-					}
-				} else {
-					if base == "tags" {
-						return nil
-					}
-					if dot := strings.LastIndexByte(base, '.'); dot >= 0 {
-						if excludeExts[strings.ToLower(base[dot:])] {
-							return nil
-						}
-					}
-				}
-			} else if ere != nil && ere.MatchString(path) {
-				if isDir {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-
-			if mode.IsDir() {
-				if path == "." || recursive || len(path) <= len(root) || dre.MatchString(path+"/") {
-					return nil
-				}
-				return filepath.SkipDir
-			}
-
-			if fre.MatchString(path) && mode.Mode().IsRegular() {
-				if verbose {
-					println("search:", path)
-				}
-				ch <- newGrepArg(pattern, path, mode.Size(), false, atty, ascii)
-			}
-			return nil
-		})
-	}
-	for i := 0; i < nworkers; i++ {
-		ch <- nil
 	}
-	if count {
-		fmt.Println(atomic.LoadInt64(&countMatch))
-	}
-	result := false
-	for i := 0; i < nworkers; i++ {
-		if <-done {
-			result = true
-		}
+
+	result, err := s.Search(ctx, args[argindex:])
+	p.Finish(s.Stats())
+	if code, ok := exitCode(err, interrupted); ok {
+		return code
 	}
 	if !result {
 		return 1
@@ -1679,93 +521,101 @@ func doMain() int {
 	return 0
 }
 
-var envre = regexp.MustCompile(`^(\$[a-zA-Z][a-zA-Z0-9_]+|\$\([a-zA-Z][a-zA-Z0-9_]+\))$`)
-
-// prepareGlob prepares glob parameters with expanding `*`, `~` and environment
-// variables in path.
-func prepareGlob(arg string) (root, globmask string) {
-	slashed := filepath.ToSlash(arg)
-	volume := filepath.VolumeName(slashed)
-	if volume != "" {
-		slashed = slashed[len(volume):]
+// doInventory walks the paths in args, or the current directory, and
+// reports the encoding of every file without searching.
+func doInventory(args []string) int {
+	if len(args) == 0 {
+		args = []string{"."}
 	}
-	for n, i := range strings.Split(slashed, "/") {
-		if root == "" && strings.Index(i, "*") != -1 {
-			if globmask == "" {
-				root = "."
-			} else {
-				root = filepath.ToSlash(globmask)
-			}
-		}
-		if n == 0 && i == "~" {
-			if runtime.GOOS == "windows" {
-				i = os.Getenv("USERPROFILE")
-			} else {
-				i = os.Getenv("HOME")
-			}
-		}
-		if envre.MatchString(i) {
-			i = strings.Trim(strings.Trim(os.Getenv(i[1:]), "()"), `"`)
-		}
-
-		globmask = filepath.Join(globmask, i)
-		if n == 0 {
-			if runtime.GOOS == "windows" && filepath.VolumeName(i) != "" {
-				globmask = i + "/"
-			} else if len(globmask) == 0 {
-				globmask = "/"
-			}
-		}
+	opts.Recursive = true
+	if encs != "" {
+		opts.Encodings = strings.Split(encs, ",")
+	} else if encEnv := os.Getenv("JVGREP_ENCODINGS"); encEnv != "" {
+		opts.Encodings = strings.Split(encEnv, ",")
 	}
-	if volume != "" {
-		root = filepath.Join(volume, root)
-		globmask = filepath.Join(volume, globmask)
+	if opts.Exclude == "" {
+		opts.Exclude = os.Getenv("JVGREP_EXCLUDE")
 	}
-	return root, globmask
-}
-
-func isASCII(s string) bool {
-	for i := 0; i < len(s); i++ {
-		if s[i] >= utf8.RuneSelf {
-			return false
+	if color == "always" {
+		opts.Color = true
+	} else if color == "" || color == "auto" {
+		opts.Color = isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+	}
+	if format == "" {
+		format = "plain"
+		if opts.Color {
+			format = "color"
 		}
 	}
-	return true
-}
-
-// isLiteralRegexp checks regexp is a simple literal or not.
-func isLiteralRegexp(expr string) bool {
-	return regexp.QuoteMeta(expr) == expr
-}
-
-func buildOutputPath(path string) string {
-	if fullpath || path == "" {
-		return path
+	p, err := grep.NewPrinter(format, stdout, &opts)
+	if err != nil {
+		errorLine(err.Error())
+		return 1
 	}
-	if fe, err := filepath.Rel(cwd, path); err == nil {
-		return fe
+	opts.Sink = p
+	s, err := grep.New(&opts)
+	if err != nil {
+		errorLine(err.Error())
+		return 1
+	}
+	ctx, cancel, interrupted := searchContext(stdout)
+	defer cancel()
+	_, err = s.Search(ctx, args)
+	p.Finish(s.Stats())
+	if code, ok := exitCode(err, interrupted); ok {
+		return code
 	}
-	return path
+	return 0
 }
 
-func newGrepArg(pattern interface{}, path string, size int64, single, atty, ascii bool) *GrepArg {
-	arg := &GrepArg{
-		pattern: pattern,
-		input:   path,
-		size:    size,
-		single:  single,
-		atty:    atty,
-		ascii:   ascii,
-		output:  buildOutputPath(path),
-	}
-	if s, ok := pattern.(string); ok {
-		arg.needle = []byte(s)
-		if ignorecase && ascii {
-			arg.folded = make([]byte, len(arg.needle))
-			lowerASCIIBytes(arg.folded, arg.needle)
-		}
-	}
-	return arg
+// searchContext returns the context of a search, which is canceled on
+// --timeout or on a signal, and a channel closed on the signal. A second
+// signal exits immediately, resetting the color written to out.
+func searchContext(out io.Writer) (context.Context, context.CancelFunc, chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		parent := cancel
+		cancel = func() {
+			cancelTimeout()
+			parent()
+		}
+	}
+
+	interrupted := make(chan struct{})
+	sc := make(chan os.Signal, 10)
+	signal.Notify(sc, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		<-sc
+		close(interrupted)
+		cancel()
+		// a second signal exits immediately
+		<-sc
+		if opts.Color {
+			out.Write([]byte(grep.ColorReset))
+		}
+		os.Exit(130)
+	}()
+	return ctx, cancel, interrupted
+}
+
+// exitCode returns the exit status for the error returned by a search.
+func exitCode(err error, interrupted chan struct{}) (int, bool) {
+	if err == nil {
+		return 0, false
+	}
+	select {
+	case <-interrupted:
+		return 130, true
+	default:
+	}
+	if err == context.DeadlineExceeded {
+		errorLine(fmt.Sprintf("jvgrep: search timed out after %v", timeout))
+		return 3, true
+	}
+	errorLine("jvgrep: " + err.Error())
+	return 1, true
 }
 
 func main() {